@@ -0,0 +1,161 @@
+// Package app はアプリケーションの起動と停止をまとめます。
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"todo-app/app/controllers"
+	"todo-app/app/mail"
+	"todo-app/app/migrations"
+	"todo-app/app/models"
+	"todo-app/config"
+	"todo-app/utils"
+)
+
+// アプリケーションの組み立て
+// 設定からロガー・データベース接続・ストア・HTTP サーバーを順に生成し、App にまとめる
+// パッケージの読み込み（init）では何も行わず、main から New・Start・Stop・Close を明示的に呼び出す
+
+// App はアプリケーションが利用する資源をまとめた構造体
+type App struct {
+	config  config.ConfigList
+	logFile *utils.RotatingFile
+	db      *sql.DB
+	dialect models.Dialect
+	store   *models.SQLStore
+	server  *controllers.Server
+	http    *http.Server
+
+	stopSweeper func()        // 期限切れセッションのスイーパーを停止する（Start で設定する）
+	done        chan struct{} // HTTP サーバーが停止すると閉じる（Start で生成する）
+	serveErr    error         // Stop によらずに HTTP サーバーが停止した理由
+}
+
+// New は設定 c に従ってロガーを設定し、データベースに接続してストアと HTTP サーバーを生成する
+// ロガーは slog の既定のロガーにする
+// データベースの準備を待っている間に ctx が取り消されると中断する
+// 返された App は使い終わったら Close で資源を解放する
+func New(ctx context.Context, c config.ConfigList) (*App, error) {
+	logger, logFile, err := utils.NewLogger(c.LogFile, c.LogLevel, c.LogFormat, c.LogRotate)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	db, dialect, err := models.OpenDB(ctx, c)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	// ストアを生成し、コントローラーに注入する
+	store := models.NewSQLStore(db, dialect, c.DbQueryTimeout)
+	server := controllers.NewServer(c, store, store, store, store, store, controllers.NewLoginLimiter(c, store), mail.NewMailer(c))
+
+	return &App{
+		config:  c,
+		logFile: logFile,
+		db:      db,
+		dialect: dialect,
+		store:   store,
+		server:  server,
+		http: &http.Server{
+			Addr:              ":" + c.Port,
+			Handler:           server.Handler(),
+			ReadTimeout:       c.ReadTimeout,
+			ReadHeaderTimeout: c.ReadHeaderTimeout,
+			WriteTimeout:      c.WriteTimeout,
+			IdleTimeout:       c.IdleTimeout,
+		},
+	}, nil
+}
+
+// DB はデータベース接続とダイアレクトを返す（migrate サブコマンドで利用する）
+func (a *App) DB() (*sql.DB, models.Dialect) {
+	return a.db, a.dialect
+}
+
+// Start はスキーマとテンプレートを確認し、HTTP サーバーとバックグラウンド処理を起動する
+// ポートの待ち受けを始めてから戻り、リクエストの処理はゴルーチンで行う
+// 未適用または失敗したマイグレーションがある場合・テンプレートに誤りがある場合・ポートが使用中の場合はエラーを返す
+func (a *App) Start() error {
+	migrator, err := migrations.New(a.db, a.dialect)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(); err != nil {
+		return fmt.Errorf("schema is not up to date (run `go run main.go migrate status` for details): %w", err)
+	}
+
+	// テンプレートをすべてパースしてキャッシュし、誤りがあれば起動しない
+	if err := a.server.Preload(); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", a.http.Addr)
+	if err != nil {
+		return err
+	}
+
+	// 期限切れセッションを定期的に削除するスイーパーを起動する
+	a.stopSweeper = models.StartSessionSweeper(a.store, a.config.SessionSweepInterval)
+
+	slog.Info("starting server", "port", a.config.Port)
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		if err := a.http.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			a.serveErr = err
+		}
+	}()
+	return nil
+}
+
+// Done は HTTP サーバーが停止すると閉じるチャネルを返す
+// Stop を呼ぶ前に閉じた場合はサーバーが異常終了しており、理由は Stop が返す
+func (a *App) Done() <-chan struct{} {
+	return a.done
+}
+
+// Stop は新しい接続の受け付けを止め、処理中のリクエストの完了を ShutdownTimeout まで待ってから
+// バックグラウンド処理を停止する（Start していない場合は何もしない）
+func (a *App) Stop() error {
+	if a.done == nil {
+		return nil
+	}
+	defer a.stopSweeper()
+
+	slog.Info("shutting down server; waiting for in-flight requests", "timeout", a.config.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.ShutdownTimeout)
+	defer cancel()
+	if err := a.http.Shutdown(ctx); err != nil {
+		// 期限内に完了しなかった接続は強制的に閉じる
+		slog.Error("graceful shutdown did not complete", "error", err)
+		a.http.Close()
+		<-a.done
+		return err
+	}
+	<-a.done
+	if a.serveErr != nil {
+		return a.serveErr
+	}
+	slog.Info("server stopped")
+	return nil
+}
+
+// ReopenLogFile はログファイルを開き直す（SIGHUP を受け取ったときに呼び出す）
+// 外部の logrotate でファイルの名前が変えられた後も、新しいファイルに書き込めるようにする
+func (a *App) ReopenLogFile() error {
+	return a.logFile.Reopen()
+}
+
+// Close はデータベース接続を閉じ、実行中の圧縮などの完了を待ってからログファイルを閉じる
+// Start した場合は先に Stop を呼び出す
+func (a *App) Close() error {
+	return errors.Join(a.db.Close(), a.logFile.Close())
+}
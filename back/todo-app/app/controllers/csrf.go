@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// CSRF（クロスサイトリクエストフォージェリ）対策
+// ブラウザごとにランダムなトークンをクッキーに保存し、状態を変更するリクエスト（POST など）では
+// フォームの csrf_token または X-CSRF-Token ヘッダーに同じトークンが含まれていることを確認する
+// 他のサイトからはクッキーの値を読めないため、トークンを含むリクエストを作ることはできない
+
+const (
+	csrfCookieName = "__csrf__"     // トークンを保持するクッキー名
+	csrfFieldName  = "csrf_token"   // フォームでトークンを送る項目名
+	csrfHeaderName = "X-CSRF-Token" // ヘッダーでトークンを送る場合のヘッダー名
+	csrfTokenBytes = 32             // トークンの長さ（バイト）
+)
+
+// csrfContextKey はリクエストのコンテキストにトークンを保存するためのキー
+type csrfContextKey struct{}
+
+// newCSRFToken はランダムなトークンを生成する
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validCSRFToken はクッキーのトークンが生成したものと同じ形式かを返す
+func validCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == csrfTokenBytes
+}
+
+// setCSRFCookie はトークンをクッキーに保存する（ブラウザを閉じるまで有効）
+func (s *Server) setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   s.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// rotateCSRFToken は新しいトークンを発行してクッキーを置き換える
+// ログイン・ログアウトの前後で同じトークンを使い回さないようにする
+func (s *Server) rotateCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, err := newCSRFToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rotateCSRFToken: error generating token", "error", err)
+		return
+	}
+	s.setCSRFCookie(w, token)
+}
+
+// csrfToken はリクエストに対応するトークンを返す（テンプレートへの埋め込み用）
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// csrfField はフォームに埋め込む hidden 項目を返す
+func csrfField(r *http.Request) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(csrfToken(r)) + `">`)
+}
+
+// safeMethod はサーバーの状態を変更しないHTTPメソッドかを返す
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrf はトークンを発行・検証するミドルウェア
+// クッキーにトークンがなければ発行し、状態を変更するリクエストではトークンが一致しない場合に 403 を返す
+// JSON API（/api/）はフォームからは送れない application/json のみを受け付けるため対象外とする
+// withFallback でルートが一致したリクエストにだけ適用し、一致しないリクエストは検証より先に 404・405 を返す
+func (s *Server) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := cookieCSRFToken(r)
+		valid := true
+		if !safeMethod(r.Method) {
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.PostFormValue(csrfFieldName)
+			}
+			valid = token != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+		}
+
+		// 拒否する場合も、エラーページのフォームと次のリクエストで使うトークンを用意する
+		r, err := s.withCSRFToken(w, r, token)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "csrf: error generating token", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			slog.WarnContext(r.Context(), "csrf: rejected request with missing or mismatched token", "method", r.Method, "path", r.URL.Path)
+			s.renderError(w, r, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCSRFToken はトークンを保存したリクエストを返す（テンプレートの csrfField・csrfToken で使う）
+// token が空の場合（クッキーにトークンがない場合）は新しく発行してクッキーに保存する
+func (s *Server) withCSRFToken(w http.ResponseWriter, r *http.Request, token string) (*http.Request, error) {
+	if token == "" {
+		var err error
+		if token, err = newCSRFToken(); err != nil {
+			return r, err
+		}
+		s.setCSRFCookie(w, token)
+	}
+	return r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token)), nil
+}
+
+// cookieCSRFToken はクッキーに保存されたトークンを返す（ないか形式が正しくない場合は空文字列）
+func cookieCSRFToken(r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && validCSRFToken(cookie.Value) {
+		return cookie.Value
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"todo-app/app/models"
+)
+
+// 状態を変更するリクエストは、クッキーと同じトークンをフォームかヘッダーで送った場合だけ処理する
+func TestCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	user, sess := ts.newUser(t, "a@example.com")
+	noCookie, malformed := "", "wrong"
+	otherToken := strings.Repeat("B", len(testCSRFToken))
+
+	tests := []struct {
+		name   string
+		req    testRequest
+		status int
+	}{
+		{"no cookie and no token", testRequest{form: url.Values{csrfFieldName: {""}}, csrf: &noCookie}, http.StatusForbidden},
+		{"no cookie", testRequest{csrf: &noCookie}, http.StatusForbidden},
+		{"no token", testRequest{form: url.Values{csrfFieldName: {""}}}, http.StatusForbidden},
+		{"wrong token", testRequest{form: url.Values{csrfFieldName: {"wrong"}}}, http.StatusForbidden},
+		{"token from another cookie", testRequest{form: url.Values{csrfFieldName: {otherToken}}}, http.StatusForbidden},
+		{"malformed cookie", testRequest{form: url.Values{csrfFieldName: {"wrong"}}, csrf: &malformed}, http.StatusForbidden},
+		{"matching token", testRequest{}, http.StatusFound},
+		{"matching header", testRequest{form: url.Values{csrfFieldName: {""}}, header: map[string]string{csrfHeaderName: testCSRFToken}}, http.StatusFound},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := fmt.Sprintf("todo %d", i)
+			tt.req.method, tt.req.path, tt.req.session = http.MethodPost, "/todos", &sess
+			if tt.req.form == nil {
+				tt.req.form = url.Values{}
+			}
+			tt.req.form.Set("content", content)
+
+			res, _ := ts.do(t, tt.req)
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			created := hasTodo(t, ts, user.ID, content)
+			if want := tt.status == http.StatusFound; created != want {
+				t.Errorf("todo created = %v, want %v", created, want)
+			}
+		})
+	}
+}
+
+// hasTodo は userID のユーザーが content の Todo を持っているかを返す
+func hasTodo(t *testing.T, ts *testServer, userID int, content string) bool {
+	t.Helper()
+	page, err := ts.store.ListTodos(context.Background(), userID, models.TodoQuery{Contains: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, todo := range page.Todos {
+		if todo.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
+// クッキーにトークンがない場合は発行し、画面のフォームに同じトークンを埋め込む
+func TestCSRFTokenIssued(t *testing.T) {
+	ts := newTestServer(t)
+	noCookie := ""
+
+	res, body := ts.do(t, testRequest{method: http.MethodGet, path: "/login", csrf: &noCookie})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	var token string
+	for _, c := range res.Cookies() {
+		if c.Name == csrfCookieName {
+			token = c.Value
+		}
+	}
+	if !validCSRFToken(token) {
+		t.Fatalf("csrf cookie = %q, want a new token", token)
+	}
+	if field := `name="` + csrfFieldName + `" value="` + token + `"`; !strings.Contains(body, field) {
+		t.Errorf("body does not contain %s", field)
+	}
+
+	// 発行されたトークンでログインのフォームを送れる（CSRF で拒否されない）
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", csrf: &token,
+		form: url.Values{csrfFieldName: {token}, "email": {"a@example.com"}, "password": {"wrong"}}})
+	if res.StatusCode == http.StatusForbidden {
+		t.Fatalf("status = %d, want the login to be attempted", res.StatusCode)
+	}
+}
+
+// JSON API は CSRF トークンを検証しない
+func TestCSRFSkipsAPI(t *testing.T) {
+	ts := newTestServer(t)
+	_, sess := ts.newUser(t, "a@example.com")
+	noCookie := ""
+
+	res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/api/v1/todos", session: &sess, csrf: &noCookie, json: `{"content":"from api"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+}
+
+// フォームの _method=DELETE は JSON API には適用せず、CSRF トークンなしで API の Todo を削除できない
+func TestMethodOverrideSkipsAPI(t *testing.T) {
+	ts := newTestServer(t)
+	user, sess := ts.newUser(t, "a@example.com")
+	todo := ts.newTodo(t, user, "todo")
+	noCookie := ""
+
+	res, _ := ts.do(t, testRequest{method: http.MethodPost, path: fmt.Sprintf("/api/v1/todos/%d", todo.ID), session: &sess, csrf: &noCookie,
+		form: url.Values{csrfFieldName: {""}, methodOverrideField: {http.MethodDelete}}})
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+	if _, err := ts.store.GetTodo(context.Background(), user.ID, todo.ID); err != nil {
+		t.Fatalf("todo was deleted: %v", err)
+	}
+}
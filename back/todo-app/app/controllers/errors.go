@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"todo-app/app/models"
+)
+
+// エラーレスポンス
+// ストアのエラーの種類（models.ErrNotFound など）を HTTP のステータスに対応付け、
+// 画面にはエラーページ、API には JSON のエラーを返す
+
+// statusClientClosedRequest はクライアントの切断で処理を中断したことをアクセスログに残すためのステータス
+// （nginx に倣った非標準のコード。クライアントには届かない）
+const statusClientClosedRequest = 499
+
+// unavailableRetryAfter はデータベースが利用できない場合に Retry-After ヘッダーで示す秒数
+const unavailableRetryAfter = "5"
+
+// errorPageData はエラーページ（error.html）に渡すデータ
+type errorPageData struct {
+	Status  int    // HTTP ステータスコード
+	Title   string // 見出し
+	Message string // 説明
+}
+
+// storeErrorStatus はストアのエラーに対応する HTTP ステータスを返す
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, models.ErrCanceled):
+		return statusClientClosedRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// logStoreError はストアのエラーをログに出力する（サーバー側の問題は error、それ以外は info レベル）
+func logStoreError(r *http.Request, op string, status int, err error) {
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(r.Context(), level, op, "status", status, "error", err)
+}
+
+// renderError はステータスに応じたエラーページを表示する
+// ログイン中（セッションクッキーがある場合）はログイン後のナビゲーションバーを表示する
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int) {
+	s.renderErrorMessage(w, r, status, "")
+}
+
+// renderErrorMessage は説明を message にしたエラーページを表示する（空の場合はステータスに応じた説明）
+// 無効なリンクなど、利用者が次に何をすればよいかを示したい場合に使う
+func (s *Server) renderErrorMessage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := errorPageData{Status: status}
+	switch status {
+	case http.StatusBadRequest:
+		data.Title = "リクエストが正しくありません"
+		data.Message = "入力内容を確認して、もう一度お試しください。"
+	case http.StatusForbidden:
+		data.Title = "ページの有効期限が切れました"
+		data.Message = "画面を読み込み直してから、もう一度お試しください。"
+	case http.StatusNotFound:
+		data.Title = "ページが見つかりません"
+		data.Message = "お探しのページは存在しないか、削除された可能性があります。"
+	case http.StatusMethodNotAllowed:
+		data.Title = "この操作は利用できません"
+		data.Message = "このページでは指定された操作を受け付けていません。"
+	case http.StatusConflict:
+		data.Title = "処理を完了できませんでした"
+		data.Message = "他の操作と競合しました。画面を読み込み直してから、もう一度お試しください。"
+	case http.StatusTooManyRequests:
+		data.Title = "しばらくお待ちください"
+		data.Message = "操作が続いたため、一時的に受け付けを制限しています。しばらくしてから、もう一度お試しください。"
+	case http.StatusServiceUnavailable:
+		data.Title = "ただいま混み合っています"
+		data.Message = "データベースに接続できません。しばらくしてから、もう一度お試しください。"
+		w.Header().Set("Retry-After", unavailableRetryAfter)
+	default:
+		data.Title = "エラーが発生しました"
+		data.Message = "サーバーでエラーが発生しました。しばらくしてから、もう一度お試しください。"
+	}
+	if message != "" {
+		data.Message = message
+	}
+
+	navbar := "public_navbar"
+	if _, err := r.Cookie(sessionCookieName); err == nil {
+		navbar = "private_navbar"
+	}
+	s.generateHTMLStatus(w, r, status, data, "layout", navbar, "error")
+}
+
+// storeError はストアのエラーをログに出力し、対応するエラーページを表示する
+func (s *Server) storeError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	status := storeErrorStatus(err)
+	logStoreError(r, op, status, err)
+	if status == statusClientClosedRequest {
+		// 切断したクライアントには何も返さない
+		w.WriteHeader(status)
+		return
+	}
+	s.renderError(w, r, status)
+}
+
+// apiStoreError はストアのエラーをログに出力し、対応する JSON のエラーを書き込む
+func apiStoreError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	status := storeErrorStatus(err)
+	logStoreError(r, op, status, err)
+	if status == statusClientClosedRequest {
+		w.WriteHeader(status)
+		return
+	}
+	message := "internal server error"
+	switch status {
+	case http.StatusNotFound:
+		message = "not found"
+	case http.StatusConflict:
+		message = "conflict"
+	case http.StatusServiceUnavailable:
+		message = "service unavailable"
+		w.Header().Set("Retry-After", unavailableRetryAfter)
+	}
+	writeJSONError(w, status, message)
+}
+
+// sessionUserError はセッションに紐づくユーザーを取得できなかった場合の処理
+// ユーザーが削除されている場合はログイン画面へリダイレクトし、それ以外はエラーページを表示する
+func (s *Server) sessionUserError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if errors.Is(err, models.ErrNotFound) {
+		slog.InfoContext(r.Context(), op+": session user not found; redirecting to /login", "error", err)
+		s.setFlash(w, flashInfo, loginRequiredMessage)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	s.storeError(w, r, op, err)
+}
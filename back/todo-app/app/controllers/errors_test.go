@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"todo-app/app/models"
+)
+
+func TestStoreErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&models.StoreError{Op: "GetTodo", Kind: models.ErrNotFound, Err: sql.ErrNoRows}, http.StatusNotFound},
+		{&models.StoreError{Op: "CreateUser", Kind: models.ErrConflict, Err: errors.New("unique violation")}, http.StatusConflict},
+		{&models.StoreError{Op: "ListTodos", Kind: models.ErrUnavailable, Err: context.DeadlineExceeded}, http.StatusServiceUnavailable},
+		{&models.StoreError{Op: "ListTodos", Kind: models.ErrCanceled, Err: context.Canceled}, statusClientClosedRequest},
+		{fmt.Errorf("wrapped: %w", &models.StoreError{Op: "GetTodo", Kind: models.ErrNotFound, Err: sql.ErrNoRows}), http.StatusNotFound},
+		{&models.StoreError{Op: "GetTodo", Err: errors.New("syntax error")}, http.StatusInternalServerError},
+		{errors.New("unknown"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := storeErrorStatus(tt.err); got != tt.want {
+			t.Errorf("storeErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// ストアのエラーの種類に応じて、画面はエラーページ、API は JSON のエラーを返す
+func TestStoreErrorResponses(t *testing.T) {
+	tests := []struct {
+		kind    error
+		status  int
+		message string // API のエラーメッセージ
+	}{
+		{models.ErrNotFound, http.StatusNotFound, "todo not found"},
+		{models.ErrConflict, http.StatusConflict, "conflict"},
+		{models.ErrUnavailable, http.StatusServiceUnavailable, "service unavailable"},
+		{models.ErrCanceled, statusClientClosedRequest, ""},
+		{nil, http.StatusInternalServerError, "internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.status), func(t *testing.T) {
+			storeErr := &models.StoreError{Op: "GetTodo", Kind: tt.kind, Err: errors.New("boom")}
+			ts := newFailingTestServer(t, map[string]error{"GetTodo": storeErr})
+			user, sess := ts.newUser(t, "a@example.com")
+			todo := ts.newTodo(t, user, "todo")
+
+			res, body := ts.do(t, testRequest{method: http.MethodGet, path: fmt.Sprintf("/todos/%d/edit", todo.ID), session: &sess})
+			checkErrorResponse(t, res, tt.status)
+			if tt.status != statusClientClosedRequest && !strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+				t.Errorf("page Content-Type = %q, want text/html", res.Header.Get("Content-Type"))
+			}
+			if tt.status == statusClientClosedRequest && body != "" {
+				t.Errorf("page body = %q, want empty", body)
+			}
+
+			res, body = ts.do(t, testRequest{method: http.MethodGet, path: fmt.Sprintf("/api/v1/todos/%d", todo.ID), session: &sess})
+			checkErrorResponse(t, res, tt.status)
+			if tt.message != "" && !strings.Contains(body, `"error":"`+tt.message+`"`) {
+				t.Errorf("API body = %q, want error %q", body, tt.message)
+			}
+		})
+	}
+}
+
+// checkErrorResponse はステータスと、503 の場合の Retry-After を確認する
+func checkErrorResponse(t *testing.T, res *http.Response, status int) {
+	t.Helper()
+	if res.StatusCode != status {
+		t.Fatalf("%s %s: status = %d, want %d", res.Request.Method, res.Request.URL.Path, res.StatusCode, status)
+	}
+	retryAfter := res.Header.Get("Retry-After")
+	if status == http.StatusServiceUnavailable && retryAfter != unavailableRetryAfter {
+		t.Errorf("%s: Retry-After = %q, want %q", res.Request.URL.Path, retryAfter, unavailableRetryAfter)
+	}
+	if status != http.StatusServiceUnavailable && retryAfter != "" {
+		t.Errorf("%s: unexpected Retry-After %q", res.Request.URL.Path, retryAfter)
+	}
+}
+
+// 無効なリンクや条件は、テキストではなく 400 のエラーページを返す
+func TestBadRequestPages(t *testing.T) {
+	ts := newTestServer(t)
+	_, sess := ts.newUser(t, "a@example.com")
+	invalidToken := url.Values{"token": {"invalid"}}
+
+	tests := []testRequest{
+		{method: http.MethodPost, path: "/unlock", form: invalidToken},
+		{method: http.MethodPost, path: "/verify", form: invalidToken},
+		{method: http.MethodPost, path: "/password/reset", form: url.Values{
+			"token": {"invalid"}, "password": {"new password 123"}, "password_confirmation": {"new password 123"},
+		}},
+		{method: http.MethodGet, path: "/todos?status=bogus", session: &sess},
+		{method: http.MethodGet, path: "/todos?cursor=bogus", session: &sess},
+	}
+	for _, tr := range tests {
+		res, body := ts.do(t, tr)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want %d", tr.method, tr.path, res.StatusCode, http.StatusBadRequest)
+		}
+		if !strings.Contains(res.Header.Get("Content-Type"), "text/html") || !strings.Contains(body, `<p class="lead">`) {
+			t.Errorf("%s %s: want the HTML error page, got %q", tr.method, tr.path, body)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// フラッシュメッセージ
+// リダイレクトの前にハンドラが設定したメッセージを署名付きのクッキーに保存し、
+// リダイレクト先の画面（layout.html）で1回だけ表示してクッキーを消す
+
+const flashCookieName = "__flash__" // メッセージを保持するクッキー名
+
+// メッセージの種類（Bootstrap の alert-* クラスに対応する）
+const (
+	flashSuccess = "success"
+	flashInfo    = "info"
+	flashError   = "danger"
+)
+
+// loginRequiredMessage は未ログイン（セッション切れ）でフォームを送信した場合に表示するメッセージ
+const loginRequiredMessage = "ログインが必要です。もう一度ログインしてください"
+
+// flash は画面に1回だけ表示するメッセージ
+type flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// newFlashKey はクッキーの署名に使う鍵を返す
+// config の [web] secret が未設定の場合は起動ごとにランダムな鍵を生成する
+// （再起動や複数サーバー間ではメッセージが引き継がれない）
+func newFlashKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	slog.Warn("flash: [web] secret is not set; using a random key for this process")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		slog.Error("flash: error generating key", "error", err)
+	}
+	return key
+}
+
+// signFlash はメッセージの署名を返す
+func (s *Server) signFlash(payload string) string {
+	mac := hmac.New(sha256.New, s.flashKey)
+	mac.Write([]byte(flashCookieName + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setFlash はリダイレクト先で表示するメッセージをクッキーに保存する
+func (s *Server) setFlash(w http.ResponseWriter, kind, message string) {
+	// 文字列のみの構造体のためエンコードは失敗しない
+	b, _ := json.Marshal(flash{Kind: kind, Message: message})
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    payload + "." + s.signFlash(payload),
+		Path:     "/",
+		Secure:   s.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlash はクッキーのメッセージを取り出し、クッキーを消す
+// メッセージがない場合や署名が一致しない場合は nil を返す
+func (s *Server) popFlash(w http.ResponseWriter, r *http.Request) *flash {
+	cookie, err := r.Cookie(flashCookieName)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   s.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	payload, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.signFlash(payload))) {
+		slog.WarnContext(r.Context(), "popFlash: discarding flash cookie with invalid signature")
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	var f flash
+	if err := json.Unmarshal(b, &f); err != nil || f.Message == "" {
+		return nil
+	}
+	return &f
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 4xx のステータスで画面を表示した場合も、フラッシュメッセージは1回表示してからクッキーを消す
+func TestFlashClearedOnErrorStatus(t *testing.T) {
+	ts := newTestServer(t)
+	_, sess := ts.newUser(t, "a@example.com")
+
+	tests := []struct {
+		name   string
+		req    testRequest
+		status int
+	}{
+		{"error page", testRequest{method: http.MethodGet, path: "/todos/999/edit"}, http.StatusNotFound},
+		{"invalid form", testRequest{method: http.MethodPost, path: "/todos", form: url.Values{"content": {""}}}, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Todo を作成してフラッシュメッセージのクッキーを受け取る
+			res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/todos", session: &sess, form: url.Values{"content": {"todo"}}})
+			flash := responseCookie(res, flashCookieName)
+			if flash == nil || flash.Value == "" {
+				t.Fatal("no flash cookie after creating a todo")
+			}
+
+			tt.req.session, tt.req.cookies = &sess, []*http.Cookie{flash}
+			res, body := ts.do(t, tt.req)
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			if !strings.Contains(body, "Todoを作成しました") {
+				t.Error("flash message is not shown")
+			}
+			if c := responseCookie(res, flashCookieName); c == nil || c.MaxAge >= 0 {
+				t.Errorf("flash cookie = %+v, want it to be cleared", c)
+			}
+		})
+	}
+}
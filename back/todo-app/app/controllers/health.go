@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+	"todo-app/app/models"
+)
+
+// 診断用のエンドポイント
+// /healthz でデータベースに接続できるかとコネクションプールの統計を JSON で返す
+// ロードバランサーや docker-compose のヘルスチェックから利用する（接続できない場合は 503）
+
+// healthResponse は /healthz が返す JSON ボディ
+type healthResponse struct {
+	Status   string         `json:"status"` // ok または unavailable
+	Database healthDatabase `json:"database"`
+}
+
+// healthDatabase はデータベースの状態とコネクションプールの統計
+type healthDatabase struct {
+	Driver             string  `json:"driver"`
+	PingMillis         float64 `json:"ping_ms"`
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitMillis         float64 `json:"wait_ms"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+// newHealthDatabase は PoolStats を JSON 用の値に変換する
+func newHealthDatabase(stats models.PoolStats) healthDatabase {
+	return healthDatabase{
+		Driver:             stats.Driver,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitMillis:         durationMillis(stats.WaitDuration),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
+
+// durationMillis は時間をミリ秒の小数で返す
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// healthz はデータベースに Ping し、状態とコネクションプールの統計を返す
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	err := s.health.Ping(r.Context())
+	elapsed := time.Since(start)
+
+	res := healthResponse{Status: "ok", Database: newHealthDatabase(s.health.PoolStats())}
+	res.Database.PingMillis = durationMillis(elapsed)
+	status := http.StatusOK
+	if err != nil {
+		logStoreError(r, "healthz: database ping failed", http.StatusServiceUnavailable, err)
+		// 接続先などが漏れないよう、エラーの詳細はログにのみ出力する
+		res.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, res)
+}
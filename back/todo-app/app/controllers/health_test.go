@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"todo-app/app/models"
+)
+
+// testHealthStore は Ping の結果とコネクションプールの統計を指定できる HealthStore
+type testHealthStore struct {
+	err   error
+	stats models.PoolStats
+}
+
+func (s testHealthStore) Ping(ctx context.Context) error { return s.err }
+func (s testHealthStore) PoolStats() models.PoolStats    { return s.stats }
+
+// /healthz はログインなしで Ping の結果とコネクションプールの統計を返し、接続できない場合は 503 を返す
+func TestHealthz(t *testing.T) {
+	stats := models.PoolStats{Driver: "postgres", DBStats: sql.DBStats{
+		MaxOpenConnections: 10,
+		OpenConnections:    3,
+		InUse:              1,
+		Idle:               2,
+		WaitCount:          4,
+		WaitDuration:       1500 * time.Microsecond,
+		MaxIdleClosed:      5,
+		MaxIdleTimeClosed:  6,
+		MaxLifetimeClosed:  7,
+	}}
+	unavailable := &models.StoreError{Op: "Ping", Kind: models.ErrUnavailable, Err: errors.New("dial tcp db.internal:5432: connection refused")}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   string
+	}{
+		{"healthy", nil, http.StatusOK, "ok"},
+		{"unavailable", unavailable, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(t)
+			store := models.NewMemoryStore()
+			health := testHealthStore{err: tt.err, stats: stats}
+			s := NewServer(c, store, store, store, store, health, models.NewMemoryLoginLimiter(LoginPolicy(c)), &testMailer{})
+			srv := httptest.NewServer(s.Handler())
+			t.Cleanup(srv.Close)
+
+			res, err := http.Get(srv.URL + "/healthz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			if got := res.Header.Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+			if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+
+			raw, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// エラーの詳細（接続先など）はレスポンスに含めない
+			if strings.Contains(string(raw), "db.internal") {
+				t.Errorf("body contains the error details: %s", raw)
+			}
+			for _, field := range []string{`"driver":"postgres"`, `"max_open_connections":10`, `"in_use":1`, `"wait_ms":1.5`, `"max_lifetime_closed":7`} {
+				if !strings.Contains(string(raw), field) {
+					t.Errorf("body does not contain %s: %s", field, raw)
+				}
+			}
+			var body healthResponse
+			if err := json.Unmarshal(raw, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != tt.want {
+				t.Errorf("status = %q, want %q", body.Status, tt.want)
+			}
+			// Ping に失敗した場合もコネクションプールの統計を返す
+			want := newHealthDatabase(stats)
+			want.PingMillis = body.Database.PingMillis
+			if body.Database != want {
+				t.Errorf("database = %+v, want %+v", body.Database, want)
+			}
+			if body.Database.WaitMillis != 1.5 {
+				t.Errorf("wait_ms = %v, want 1.5", body.Database.WaitMillis)
+			}
+		})
+	}
+}
+
+// MemoryStore を使う場合は種類のみを返し、取り消されていなければ成功する
+func TestHealthzMemoryStore(t *testing.T) {
+	ts := newTestServer(t)
+	res, body := ts.do(t, testRequest{method: http.MethodGet, path: "/healthz"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	var got healthResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "ok" || got.Database.Driver != "memory" || got.Database.MaxOpenConnections != 0 {
+		t.Errorf("body = %+v, want ok with driver memory and no pool stats", got)
+	}
+}
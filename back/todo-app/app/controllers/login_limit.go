@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"todo-app/app/models"
+)
+
+// ログイン試行の制限
+// authenticate ハンドラから呼び出し、メールアドレスごと・接続元IPごとに試行を予約し、
+// 失敗しなかった試行の予約を取り消す
+// 制限の記録に失敗した場合はログに出力し、ログイン自体は止めない
+
+// clientIP はリクエストの接続元IPアドレスを返す
+// リバースプロキシの X-Forwarded-For は偽装できるため使わない
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginKeys はログイン試行を数えるキー（メールアドレスと接続元IP）を返す
+func loginKeys(r *http.Request, email string) []models.LoginKey {
+	return []models.LoginKey{models.EmailLoginKey(email), models.IPLoginKey(clientIP(r))}
+}
+
+// loginAttempt は試行を予約したキーと、予約した（失敗として記録した）時点の状況
+type loginAttempt struct {
+	key    models.LoginKey
+	status models.LoginStatus
+}
+
+// reserveLogin はパスワードを照合する前に、各キーの試行を予約する（失敗として先に記録する）
+// 待ち時間中のキーがある場合は予約を取り消し、最も長い待ち時間を返す
+// 確認と記録を不可分に行うため、同時に送られた試行も制限を超えて認証されない
+func (s *Server) reserveLogin(ctx context.Context, keys []models.LoginKey, now time.Time) ([]loginAttempt, time.Duration) {
+	var attempts []loginAttempt
+	var wait time.Duration
+	for _, key := range keys {
+		st, w, err := s.limiter.Reserve(ctx, key, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "login limiter: error reserving attempt", "key", key.String(), "error", err)
+			continue
+		}
+		if w > 0 {
+			wait = max(wait, w)
+			continue
+		}
+		attempts = append(attempts, loginAttempt{key: key, status: st})
+	}
+	if wait > 0 {
+		s.releaseLogin(ctx, attempts, now)
+		return nil, wait
+	}
+	return attempts, 0
+}
+
+// releaseLogin は予約を取り消す（DB の障害などでログインの成否を判断できなかった場合）
+func (s *Server) releaseLogin(ctx context.Context, attempts []loginAttempt, now time.Time) {
+	for _, a := range attempts {
+		if err := s.limiter.Release(ctx, a.key, now); err != nil {
+			slog.ErrorContext(ctx, "login limiter: error releasing attempt", "key", a.key.String(), "error", err)
+		}
+	}
+}
+
+// loginFailed は予約した試行を失敗として確定する
+// この試行で上限に達してメールアドレスがロックされた場合は、登録済みのユーザーにロック解除のメールを送る
+func (s *Server) loginFailed(ctx context.Context, attempts []loginAttempt, user *models.User, now time.Time) {
+	for _, a := range attempts {
+		if !a.status.LockedUntil.Equal(now.Add(s.loginPolicy.Lockout)) {
+			continue
+		}
+		// この失敗で上限に達してロックされた
+		slog.WarnContext(ctx, "login limiter: locked", "key", a.key.String(), "locked_until", a.status.LockedUntil, "failures", a.status.Failures)
+		if a.key.Kind == models.LoginKeyEmail && user != nil {
+			s.sendUnlockMail(ctx, *user, a.status.LockedUntil)
+		}
+	}
+}
+
+// loginSucceeded はログイン成功時にメールアドレスの失敗記録を消し、接続元IPの予約を取り消す
+// 接続元IPのそれまでの失敗は、1つのアカウントで他のアカウントへの試行を続けられないよう残す
+func (s *Server) loginSucceeded(ctx context.Context, attempts []loginAttempt, email string, now time.Time) {
+	s.resetLoginFailures(ctx, email)
+	for _, a := range attempts {
+		if a.key.Kind == models.LoginKeyIP {
+			s.releaseLogin(ctx, []loginAttempt{a}, now)
+		}
+	}
+}
+
+// resetLoginFailures はメールアドレスの失敗記録とロックを消す（ログイン成功時やパスワードの再設定時）
+func (s *Server) resetLoginFailures(ctx context.Context, email string) {
+	if err := s.limiter.Reset(ctx, models.EmailLoginKey(email)); err != nil {
+		slog.ErrorContext(ctx, "login limiter: error resetting failures", "error", err)
+	}
+}
+
+// sendUnlockMail はロック解除用のリンクを記載したメールを送る
+func (s *Server) sendUnlockMail(ctx context.Context, user models.User, lockedUntil time.Time) {
+	s.sendTokenMail(ctx, user, tokenMail{
+		purpose: models.TokenPurposeUnlock,
+		ttl:     s.config.UnlockTokenTTL,
+		path:    "/unlock",
+		subject: "アカウントがロックされました",
+		body: func(link string, ttl time.Duration) string {
+			return fmt.Sprintf("ログインの失敗が続いたため、アカウントを %s までロックしました。\n"+
+				"ご自身の操作であれば、以下のリンクからすぐにロックを解除できます（有効期限: %s）。\n\n%s\n",
+				lockedUntil.Format("2006-01-02 15:04"), ttl, link)
+		},
+	})
+}
+
+// tooManyLoginAttempts は試行回数の制限により 429 のエラーページを返す
+func (s *Server) tooManyLoginAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	s.renderErrorMessage(w, r, http.StatusTooManyRequests, fmt.Sprintf("ログインの失敗が続いたため、一時的にログインを制限しています。%d 秒後にもう一度お試しください。", seconds))
+}
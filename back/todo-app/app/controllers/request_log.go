@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+	"todo-app/utils"
+)
+
+// リクエストIDとアクセスログ
+// リクエストごとにIDを発行してコンテキストに保存し、そのリクエストの処理中のログすべてに request_id として付ける
+// IDはレスポンスの X-Request-ID ヘッダーでも返し、利用者からの問い合わせとログを突き合わせられるようにする
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID はリバースプロキシなどから受け取ったリクエストIDとして受け付ける形式
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestID はランダムなリクエストIDを生成する
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder はレスポンスのステータスコードを記録する http.ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader はステータスコードを記録してから書き込む
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write は WriteHeader が呼ばれていなければ 200 として記録してから書き込む
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// requestLogger はリクエストIDを発行し、処理の完了後にアクセスログを出力するミドルウェア
+// 受け取った X-Request-ID ヘッダーが妥当な形式であればそれを引き継ぐ
+// クエリ文字列にはトークンが含まれることがあるため、ログにはパスのみを出力する
+// 処理中にクライアントが切断した場合は canceled=true を付ける
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := utils.WithRequestID(r.Context(), id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"remote", clientIP(r),
+		}
+		if ctx.Err() != nil {
+			attrs = append(attrs, "canceled", true)
+		}
+		slog.Log(ctx, level, "request", attrs...)
+	})
+}
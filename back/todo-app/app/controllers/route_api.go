@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+	"todo-app/app/models"
+	"todo-app/app/validation"
+)
+
+// JSON API のハンドラ
+// /api/v1/todos 以下を JSON で提供し、スクリプトやモバイルクライアントから利用できるようにする
+
+// apiError はエラー時に返す JSON ボディ
+// 入力エラー（422）の場合は Errors に項目名ごとのメッセージを入れる
+type apiError struct {
+	Error  string            `json:"error"`
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// todoRequest は Todo 作成・更新時に受け付ける JSON ボディ
+// PUT は全体の置き換えとして扱い、省略した項目は既定値（未完了・期限なし・優先度なし）になる
+type todoRequest struct {
+	Content  string     `json:"content"`
+	Done     bool       `json:"done"`
+	DueAt    *time.Time `json:"due_at"`
+	Priority int        `json:"priority"`
+}
+
+// apply はリクエストの内容を Todo に設定する
+// 期限はクライアントが指定したオフセットのままにせず、画面のフォームと同じくサーバーのローカル時刻にそろえる
+func (req todoRequest) apply(todo *models.Todo) {
+	todo.Content = req.Content
+	todo.SetDone(req.Done, time.Now())
+	todo.DueAt = nil
+	if req.DueAt != nil {
+		due := req.DueAt.In(time.Local)
+		todo.DueAt = &due
+	}
+	todo.Priority = req.Priority
+}
+
+// writeJSON はステータスコードを設定し、値を JSON にエンコードして書き込む
+// v が nil の場合はボディを書き込まない（204 No Content 用）
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writeJSON: encode error", "error", err)
+	}
+}
+
+// writeJSONError はエラーメッセージを JSON ボディとして書き込む
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// apiUserTodo はログイン中のユーザーが所有する Todo をパスの {id} で取得する
+// ID が不正、存在しない、または他人の Todo の場合は 404 を書き込み、ok=false を返す
+func (s *Server) apiUserTodo(w http.ResponseWriter, r *http.Request) (todo models.Todo, ok bool) {
+	id, ok := pathID(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "todo not found")
+		return todo, false
+	}
+	todo, err := s.todos.GetTodo(r.Context(), currentUser(r).ID, id)
+	if errors.Is(err, models.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "todo not found")
+		return todo, false
+	}
+	if err != nil {
+		apiStoreError(w, r, "apiUserTodo: error getting todo", err)
+		return todo, false
+	}
+	return todo, true
+}
+
+// decodeTodoRequest はリクエストボディを読み込み、内容を検証する
+// Content-Type が application/json 以外は 415、不正な JSON は 400、
+// 空または長すぎる内容や範囲外の優先度は項目ごとのエラーを付けて 422 を書き込み、ok=false を返す
+// 内容の検証は画面のフォームと同じ validation.Todo で行う
+// application/json は他サイトのフォームから送信できないため、CSRF 対策も兼ねる
+func decodeTodoRequest(w http.ResponseWriter, r *http.Request) (req todoRequest, ok bool) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return req, false
+	}
+	errs := validation.Todo(req.Content)
+	if !models.ValidPriority(req.Priority) {
+		errs.Add("priority", "優先度は0（なし）から3（高）の範囲で指定してください")
+	}
+	if !errs.Valid() {
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "validation failed", Errors: errs})
+		return req, false
+	}
+	return req, true
+}
+
+// apiListTodos はログイン中のユーザーの Todo 一覧を返す（GET /api/v1/todos）
+// 絞り込み・並び替え・ページングは parseTodoQuery を参照
+func (s *Server) apiListTodos(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	q, err := parseTodoQuery(values)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := s.todos.ListTodos(r.Context(), currentUser(r).ID, q)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		apiStoreError(w, r, "apiListTodos: error listing todos", err)
+		return
+	}
+	// 次のページがある場合は Link ヘッダーでURLを示す
+	values.Del("cursor")
+	if next := nextPageURL("/api/v1/todos", values, page.NextCursor); next != "" {
+		w.Header().Set("Link", "<"+next+`>; rel="next"`)
+	}
+	// 0件の場合も null ではなく空配列を返す
+	todos := page.Todos
+	if todos == nil {
+		todos = []models.Todo{}
+	}
+	writeJSON(w, http.StatusOK, todos)
+}
+
+// apiCreateTodo は Todo を作成する（POST /api/v1/todos）
+func (s *Server) apiCreateTodo(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTodoRequest(w, r)
+	if !ok {
+		return
+	}
+	todo := models.Todo{UserID: currentUser(r).ID}
+	req.apply(&todo)
+	if err := s.todos.CreateTodo(r.Context(), &todo); err != nil {
+		apiStoreError(w, r, "apiCreateTodo: error creating todo", err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/todos/"+strconv.Itoa(todo.ID))
+	writeJSON(w, http.StatusCreated, todo)
+}
+
+// apiGetTodo は Todo を返す（GET /api/v1/todos/{id}）
+func (s *Server) apiGetTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := s.apiUserTodo(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// apiUpdateTodo は Todo を更新する（PUT /api/v1/todos/{id}）
+func (s *Server) apiUpdateTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := s.apiUserTodo(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeTodoRequest(w, r)
+	if !ok {
+		return
+	}
+	req.apply(&todo)
+	if err := s.todos.UpdateTodo(r.Context(), &todo); err != nil {
+		apiStoreError(w, r, "apiUpdateTodo: error updating todo", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// apiDeleteTodo は Todo を削除する（DELETE /api/v1/todos/{id}）
+func (s *Server) apiDeleteTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := s.apiUserTodo(w, r)
+	if !ok {
+		return
+	}
+	if err := s.todos.DeleteTodo(r.Context(), currentUser(r).ID, todo.ID); err != nil {
+		apiStoreError(w, r, "apiDeleteTodo: error deleting todo", err)
+		return
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
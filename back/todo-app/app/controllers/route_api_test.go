@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"todo-app/app/models"
+)
+
+// 入力エラーは画面のフォームと同じ検証を行い、422 で項目ごとのエラーを返す
+func TestAPITodoValidationErrors(t *testing.T) {
+	ts := newTestServer(t)
+	_, sess := ts.newUser(t, "a@example.com")
+
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"empty content", `{"content": "   "}`, []string{"content"}},
+		{"too long content", `{"content": "` + strings.Repeat("あ", 1001) + `"}`, []string{"content"}},
+		{"priority out of range", `{"content": "ok", "priority": 4}`, []string{"priority"}},
+		{"both", `{"content": "", "priority": -1}`, []string{"content", "priority"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := ts.do(t, testRequest{method: http.MethodPost, path: "/api/v1/todos", session: &sess, json: tt.body})
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d (%s)", res.StatusCode, http.StatusUnprocessableEntity, body)
+			}
+			var got apiError
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Errors) != len(tt.fields) {
+				t.Errorf("errors = %v, want fields %v", got.Errors, tt.fields)
+			}
+			for _, field := range tt.fields {
+				if got.Errors[field] == "" {
+					t.Errorf("errors = %v, want a message for %q", got.Errors, field)
+				}
+			}
+		})
+	}
+}
+
+// クライアントのオフセットで指定した期限は、同じ時刻のままサーバーのローカル時刻にそろえて保存する
+func TestAPITodoDueAtNormalized(t *testing.T) {
+	ts := newTestServer(t)
+	user, sess := ts.newUser(t, "a@example.com")
+
+	// サーバーのタイムゾーンとは異なるはずのオフセット（+05:45）で指定する
+	const dueAt = "2030-01-02T05:00:00+05:45"
+	want, err := time.Parse(time.RFC3339, dueAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body := ts.do(t, testRequest{method: http.MethodPost, path: "/api/v1/todos", session: &sess,
+		json: `{"content": "report", "due_at": "` + dueAt + `"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want %d (%s)", res.StatusCode, http.StatusCreated, body)
+	}
+	var created models.Todo
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := ts.store.GetTodo(context.Background(), user.ID, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DueAt == nil || !stored.DueAt.Equal(want) {
+		t.Fatalf("stored due_at = %v, want %v", stored.DueAt, want)
+	}
+	if stored.DueAt.Location() != time.Local {
+		t.Errorf("stored due_at location = %v, want Local", stored.DueAt.Location())
+	}
+	if got, wantLocal := created.DueAt.Format(time.RFC3339), want.In(time.Local).Format(time.RFC3339); got != wantLocal {
+		t.Errorf("response due_at = %s, want %s", got, wantLocal)
+	}
+}
@@ -1,139 +1,273 @@
 package controllers
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 	"todo-app/app/models"
+	"todo-app/app/validation"
 )
 
 // ハンドラ
 // w：http.ResponseWriter クライアントへのレスポンスを書き込むためのオブジェクト（出力）
 // r：*http.Request クライアントから送られてきたリクエスト情報（入力）
 
-// signupハンドラ: ユーザーサインアップ処理を担当
-// GET: サインアップフォーム表示、POST: ユーザー登録処理
-func signup(w http.ResponseWriter, r *http.Request) {
-	// リクエストメソッドで分岐
-	if r.Method == "GET" {
-		_, err := session(w, r)
-		if err != nil {
-			// 未ログイン時はサインアップフォームを表示
-			generateHTML(w, nil, "layout", "signup", "public_navbar")
-		} else {
-			// ログイン済みならTodo一覧へリダイレクト
-			http.Redirect(w, r, "/todos", http.StatusFound)
-		}
-	} else if r.Method == "POST" {
-		// フォーム送信時の処理
-		err := r.ParseForm()
-		if err != nil {
-			log.Println("Form parsing error:", err)
-		}
-		// フォーム値からユーザー情報を生成
-		user := models.User{
-			Name:     r.PostFormValue("name"),
-			Email:    r.PostFormValue("email"),
-			PassWord: r.PostFormValue("password"),
-		}
-		// DBにユーザー登録
-		if err := user.CreateUser(); err != nil {
-			log.Println("Database user creation error:", err)
-		} else {
-			log.Println("User created successfully.")
-			http.Redirect(w, r, "/", http.StatusFound)
-		}
-	}
+// signupData は signup テンプレートに渡すデータ
+// エラー時に入力済みの名前・メールアドレスをフォームに戻す
+type signupData struct {
+	Name   string            // 入力された名前
+	Email  string            // 入力されたメールアドレス
+	Errors validation.Errors // 項目ごとの入力エラー
 }
 
-// loginハンドラ: ログインフォーム表示のみ担当
-func login(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "GET" {
-		_, err := session(w, r)
-		if err != nil {
-			generateHTML(w, nil, "layout", "login", "public_navbar")
-		} else {
-			http.Redirect(w, r, "/todos", http.StatusFound)
-		}
+// verifySentData は verify_sent テンプレートに渡すデータ
+type verifySentData struct {
+	Email string // 確認メールの送信先
+}
+
+// signupFormハンドラ: サインアップフォームを表示する（GET /signup）
+// ログイン済みの場合は guestOnly が Todo 一覧へリダイレクトする
+func (s *Server) signupForm(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, signupData{}, "layout", "signup", "public_navbar")
+}
+
+// signupハンドラ: ユーザー登録処理を担当（POST /signup）
+// 登録後はメールアドレスの確認が済むまでログインできない
+func (s *Server) signup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.WarnContext(r.Context(), "signup: form parse error", "error", err)
+	}
+	// 入力を検証し、エラーがあれば入力値を残したままフォームを再表示する
+	data := signupData{
+		Name:  strings.TrimSpace(r.PostFormValue("name")),
+		Email: strings.TrimSpace(r.PostFormValue("email")),
+	}
+	password := r.PostFormValue("password")
+	data.Errors = validation.Signup(data.Name, data.Email, password)
+	if !data.Errors.Valid() {
+		slog.InfoContext(r.Context(), "signup: rejected invalid input", "errors", data.Errors.Error())
+		s.generateHTMLStatus(w, r, http.StatusUnprocessableEntity, data, "layout", "signup", "public_navbar")
+		return
 	}
+
+	// フォーム値からユーザー情報を生成（パスワードはハッシュ化して保存）
+	hashed, err := models.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "signup: password hashing error", "error", err)
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	user := models.User{
+		Name:     data.Name,
+		Email:    data.Email,
+		PassWord: hashed,
+	}
+	// DBにユーザー登録
+	err = s.users.CreateUser(r.Context(), &user)
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		// 登録済みのメールアドレスはフォームにエラーを表示する
+		slog.InfoContext(r.Context(), "signup: rejected duplicate email", "email", user.Email)
+		data.Errors.Add("email", "このメールアドレスは既に登録されています")
+		s.generateHTMLStatus(w, r, http.StatusConflict, data, "layout", "signup", "public_navbar")
+		return
+	}
+	if err != nil {
+		s.storeError(w, r, "signup: error creating user", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "signup: user created; sending verification mail", "user_id", user.ID)
+	s.sendVerifyMail(r.Context(), user)
+	s.generateHTML(w, r, verifySentData{Email: user.Email}, "layout", "public_navbar", "verify_sent")
+}
+
+// loginハンドラ: ログインフォーム表示のみ担当（GET /login）
+// ログイン済みの場合は guestOnly が Todo 一覧へリダイレクトする
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, nil, "layout", "login", "public_navbar")
 }
 
 // authenticateハンドラ: ログイン認証処理を担当
-func authenticate(w http.ResponseWriter, r *http.Request) {
-	log.Println("authenticate handler started")
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "authenticate: started")
 	// フォームデータをパース。POSTで送信された値を扱うため必須
 	err := r.ParseForm()
 	if err != nil {
-		log.Println("Form parse error in authenticate:", err)
+		slog.WarnContext(r.Context(), "authenticate: form parse error", "error", err)
 		// フォームパース失敗時は400エラーを返して終了
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		s.renderError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// 失敗が続いている場合は待ち時間が過ぎるまで（ロック中は解除まで）認証しない
+	// 認証できる場合は、同時に送られた試行も数えられるよう失敗として先に記録する
+	email := r.PostFormValue("email")
+	now := time.Now()
+	attempts, wait := s.reserveLogin(r.Context(), loginKeys(r, email), now)
+	if wait > 0 {
+		slog.WarnContext(r.Context(), "authenticate: rejected by login limiter", "email", email, "remote", clientIP(r), "retry_in", wait.Round(time.Second))
+		s.tooManyLoginAttempts(w, r, wait)
 		return
 	}
 
 	// 入力されたメールアドレスでユーザーをDBから検索
-	log.Println("Attempting to get user by email:", r.PostFormValue("email"))
-	user, err := models.GetUserByEmail(r.PostFormValue("email"))
+	slog.DebugContext(r.Context(), "authenticate: looking up user", "email", email)
+	user, err := s.users.GetUserByEmail(r.Context(), email)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		// DB の障害などはログイン失敗として数えず（予約を取り消し）、エラーページを表示
+		s.releaseLogin(r.Context(), attempts, now)
+		s.storeError(w, r, "authenticate: error looking up user", err)
+		return
+	}
 	if err != nil {
-		// ユーザーが見つからない場合はログイン画面へリダイレクト
-		log.Println("Error getting user by email:", err)
+		// ユーザーが見つからない場合も試行を失敗として確定し、ログイン画面へリダイレクト
+		slog.InfoContext(r.Context(), "authenticate: unknown email", "email", email, "error", err)
+		s.loginFailed(r.Context(), attempts, nil, now)
+		s.setFlash(w, flashError, "メールアドレスまたはパスワードが正しくありません")
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 
-	// パスワード照合（DB保存値はハッシュ化済みなので同じ関数で暗号化して比較）
-	log.Println("User found, comparing passwords.")
-	if user.PassWord == models.Encrypt(r.PostFormValue("password")) {
+	// パスワード照合（旧方式のハッシュは照合成功時に新方式へ再ハッシュされる）
+	slog.DebugContext(r.Context(), "authenticate: user found; comparing passwords", "user_id", user.ID)
+	if models.Authenticate(r.Context(), s.users, &user, r.PostFormValue("password")) {
+		s.loginSucceeded(r.Context(), attempts, email, now)
+		// メールアドレスが未確認の場合はログインさせず、確認メールを送り直す
+		if !user.Verified() {
+			slog.InfoContext(r.Context(), "authenticate: email not verified; resending verification mail", "user_id", user.ID)
+			s.sendVerifyMail(r.Context(), user)
+			s.generateHTML(w, r, verifySentData{Email: user.Email}, "layout", "public_navbar", "verify_sent")
+			return
+		}
+
 		// パスワード一致時はセッション作成
-		log.Println("Password matched. Creating session.")
-		session, err := user.CreateSession()
+		slog.DebugContext(r.Context(), "authenticate: password matched; creating session", "user_id", user.ID)
+		session, err := s.sessions.CreateSession(r.Context(), &user, s.policy.Expiry(now, now))
 		if err != nil {
 			// セッション作成失敗時はログイン画面へリダイレクト
-			log.Println("Error creating session:", err)
+			slog.ErrorContext(r.Context(), "authenticate: error creating session", "error", err)
+			s.setFlash(w, flashError, "ログインに失敗しました。しばらくしてからもう一度お試しください")
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
 
-		// セッションUUIDをクッキーに保存（HttpOnlyでJSからアクセス不可）
-		log.Println("Session created. Setting cookie.")
-		cookie := http.Cookie{
-			Name:     "__cookie__",
-			Value:    session.UUID,
-			HttpOnly: true,
-		}
-		http.SetCookie(w, &cookie)
+		// セッションUUIDをクッキーに保存（HttpOnlyでJSからアクセス不可、有効期限はセッションに合わせる）
+		slog.DebugContext(r.Context(), "authenticate: session created; setting cookie", "user_id", user.ID)
+		s.setSessionCookie(w, session)
+		// ログイン前のCSRFトークンを使い回さないよう新しいトークンを発行
+		s.rotateCSRFToken(w, r)
 
 		// 認証成功後はTodo一覧へリダイレクト
-		log.Println("Cookie set. Redirecting to /todos.")
+		slog.InfoContext(r.Context(), "authenticate: login succeeded", "user_id", user.ID)
+		s.setFlash(w, flashSuccess, "ログインしました")
 		http.Redirect(w, r, "/todos", http.StatusFound)
 	} else {
-		// パスワード不一致時はログイン画面へリダイレクト
-		log.Println("Incorrect password for email:", r.PostFormValue("email"))
+		// パスワード不一致時は試行を失敗として確定し、ログイン画面へリダイレクト
+		slog.InfoContext(r.Context(), "authenticate: incorrect password", "user_id", user.ID)
+		s.loginFailed(r.Context(), attempts, &user, now)
+		s.setFlash(w, flashError, "メールアドレスまたはパスワードが正しくありません")
 		http.Redirect(w, r, "/login", http.StatusFound)
 	}
 }
 
+// unlockFormハンドラ: ロック解除メールのリンク先の確認画面を表示する（GET /unlock）
+// メールのリンクの先読みで解除されないよう、解除は確認画面からの POST で行う
+func (s *Server) unlockForm(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, r.URL.Query().Get("token"), "layout", "public_navbar", "unlock")
+}
+
+// unlockハンドラ: トークンを検証してアカウントのロックを解除する（POST /unlock）
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.tokens.ConsumeUserToken(r.Context(), models.TokenPurposeUnlock, r.PostFormValue("token"))
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		s.storeError(w, r, "unlock: error consuming token", err)
+		return
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "unlock: invalid or expired token", "error", err)
+		s.renderErrorMessage(w, r, http.StatusBadRequest, "ロック解除のリンクが正しくないか、有効期限が切れています。ロックの期限が過ぎると、再びログインできるようになります。")
+		return
+	}
+	user, err := s.users.GetUser(r.Context(), userID)
+	if err != nil {
+		s.storeError(w, r, "unlock: error getting user", err)
+		return
+	}
+	if err := s.limiter.Reset(r.Context(), models.EmailLoginKey(user.Email)); err != nil {
+		s.storeError(w, r, "unlock: error resetting login failures", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "unlock: account unlocked via unlock link", "user_id", user.ID)
+	s.setFlash(w, flashSuccess, "アカウントのロックを解除しました。ログインしてください")
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// sendVerifyMail はメールアドレス確認用のリンクを記載したメールを送る
+func (s *Server) sendVerifyMail(ctx context.Context, user models.User) {
+	s.sendTokenMail(ctx, user, tokenMail{
+		purpose: models.TokenPurposeVerify,
+		ttl:     s.config.VerifyTokenTTL,
+		path:    "/verify",
+		subject: "メールアドレスの確認",
+		body: func(link string, ttl time.Duration) string {
+			return fmt.Sprintf("%s さん、ご登録ありがとうございます。\n"+
+				"以下のリンクからメールアドレスを確認すると、ログインできるようになります（有効期限: %s）。\n\n%s\n",
+				user.Name, ttl, link)
+		},
+	})
+}
+
+// verifyFormハンドラ: 確認メールのリンク先の確認画面を表示する（GET /verify）
+// メールのリンクの先読みで確認済みにならないよう、確認は確認画面からの POST で行う
+func (s *Server) verifyForm(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, r.URL.Query().Get("token"), "layout", "public_navbar", "verify")
+}
+
+// verifyハンドラ: トークンを検証してメールアドレスを確認済みにする（POST /verify）
+func (s *Server) verify(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.tokens.ConsumeUserToken(r.Context(), models.TokenPurposeVerify, r.PostFormValue("token"))
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		s.storeError(w, r, "verify: error consuming token", err)
+		return
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "verify: invalid or expired token", "error", err)
+		s.renderErrorMessage(w, r, http.StatusBadRequest, "確認のリンクが正しくないか、有効期限が切れています。ログインすると、新しい確認メールをお送りします。")
+		return
+	}
+	if err := s.users.MarkVerified(r.Context(), userID, time.Now()); err != nil {
+		s.storeError(w, r, "verify: error marking user as verified", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "verify: email address verified", "user_id", userID)
+	s.setFlash(w, flashSuccess, "メールアドレスを確認しました。ログインしてください")
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
 // logoutハンドラ: ログアウト処理を担当
-func logout(w http.ResponseWriter, r *http.Request) {
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
 	// クッキーからセッションUUIDを取得。未ログイン時はエラーになる
-	cookie, err := r.Cookie("__cookie__")
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
-		log.Println(err)
+		slog.DebugContext(r.Context(), "logout: no session cookie", "error", err)
 	}
 
 	if err != http.ErrNoCookie {
 		// セッションUUIDが存在する場合はDBから該当セッションを削除
-		session := models.Session{UUID: cookie.Value}
-		session.DeleteSessionByUUID()
+		if err := s.sessions.DeleteSessionByUUID(r.Context(), cookie.Value); err != nil {
+			slog.ErrorContext(r.Context(), "logout: error deleting session", "error", err)
+		}
 	}
 
 	// セッションクッキーを無効化（MaxAge=-1で即時削除）
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__cookie__",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
+	s.clearSessionCookie(w)
+	s.rotateCSRFToken(w, r)
 
 	// ログアウト後はログイン画面へリダイレクト
+	s.setFlash(w, flashInfo, "ログアウトしました")
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loginForm はログインのフォームの項目を返す
+func loginForm(email, password string) url.Values {
+	return url.Values{"email": {email}, "password": {password}}
+}
+
+// authenticate はログインのフォームを送り、ステータスを返す（ゴルーチンから呼べるよう t を使わない）
+func (ts *testServer) authenticate(email, password string) (int, error) {
+	form := loginForm(email, password)
+	form.Set(csrfFieldName, testCSRFToken)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/authenticate", strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: testCSRFToken})
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// 失敗した直後の試行は待ち時間が過ぎるまで 429 を返す
+func TestLoginDelay(t *testing.T) {
+	c := testConfig(t)
+	c.LoginBaseDelay = time.Minute
+	ts := newConfiguredTestServer(t, c, nil)
+	ts.newUser(t, "a@example.com")
+
+	res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("a@example.com", "wrong")})
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/login" {
+		t.Fatalf("first failure: status = %d, Location = %q, want redirect to /login", res.StatusCode, res.Header.Get("Location"))
+	}
+	// 待ち時間中は正しいパスワードでも認証しない
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("a@example.com", testPassword)})
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("during delay: status = %d, want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	checkRetryAfter(t, res, c.LoginBaseDelay)
+}
+
+// checkRetryAfter は Retry-After が max 以下の正の秒数であることを確認する
+func checkRetryAfter(t *testing.T, res *http.Response, max time.Duration) {
+	t.Helper()
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 || seconds > int(max.Seconds()) {
+		t.Errorf("Retry-After = %q, want 1..%d", res.Header.Get("Retry-After"), int(max.Seconds()))
+	}
+}
+
+// 失敗回数が上限に達するとロックし、ロック解除のメールを送る
+func TestLoginLockout(t *testing.T) {
+	c := testConfig(t)
+	c.LoginBaseDelay = 0
+	c.LoginMaxEmailFailures = 3
+	ts := newConfiguredTestServer(t, c, nil)
+	ts.newUser(t, "a@example.com")
+
+	for i := range c.LoginMaxEmailFailures {
+		res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("a@example.com", "wrong")})
+		if res.StatusCode != http.StatusFound {
+			t.Fatalf("failure %d: status = %d, want %d", i+1, res.StatusCode, http.StatusFound)
+		}
+	}
+	if len(ts.mailer.sent) != 1 || ts.mailer.sent[0].To != "a@example.com" {
+		t.Fatalf("sent = %+v, want one unlock mail to a@example.com", ts.mailer.sent)
+	}
+
+	res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("a@example.com", testPassword)})
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("locked: status = %d, want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	checkRetryAfter(t, res, c.LoginLockout)
+}
+
+// 同時に送られた試行も1回ずつ数え、待ち時間中の試行はパスワードを照合せずに 429 を返す
+func TestLoginConcurrentAttempts(t *testing.T) {
+	c := testConfig(t)
+	c.LoginBaseDelay = time.Minute
+	ts := newConfiguredTestServer(t, c, nil)
+	ts.newUser(t, "a@example.com")
+
+	const n = 8
+	var wg sync.WaitGroup
+	statuses := make([]int, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := ts.authenticate("a@example.com", "wrong")
+			if err != nil {
+				t.Error(err)
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+
+	attempted := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusFound:
+			attempted++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("status = %d, want %d or %d", status, http.StatusFound, http.StatusTooManyRequests)
+		}
+	}
+	if attempted != 1 {
+		t.Fatalf("%d of %d concurrent attempts were authenticated, want 1", attempted, n)
+	}
+}
+
+// ログインに成功した試行は接続元IPの失敗として数えない
+func TestLoginSuccessNotCountedForIP(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "a@example.com")
+	ts.newUser(t, "b@example.com")
+
+	res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("a@example.com", testPassword)})
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/todos" {
+		t.Fatalf("login: status = %d, Location = %q, want redirect to /todos", res.StatusCode, res.Header.Get("Location"))
+	}
+	// 同じ接続元IPから、すぐに別のアカウントでログインできる
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm("b@example.com", testPassword)})
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/todos" {
+		t.Fatalf("second login: status = %d, Location = %q, want redirect to /todos", res.StatusCode, res.Header.Get("Location"))
+	}
+}
+
+// mailLink は最後に送ったメールの本文から path へのリンクを取り出す
+func (ts *testServer) mailLink(t *testing.T, to, path string) *url.URL {
+	t.Helper()
+	ts.mailer.mu.Lock()
+	defer ts.mailer.mu.Unlock()
+	if len(ts.mailer.sent) == 0 {
+		t.Fatal("no mail was sent")
+	}
+	msg := ts.mailer.sent[len(ts.mailer.sent)-1]
+	if msg.To != to {
+		t.Fatalf("mail sent to %q, want %q", msg.To, to)
+	}
+	for _, field := range strings.Fields(msg.Body) {
+		if u, err := url.Parse(field); err == nil && u.Path == path && u.Query().Get("token") != "" {
+			return u
+		}
+	}
+	t.Fatalf("mail body does not contain a link to %s:\n%s", path, msg.Body)
+	return nil
+}
+
+// 登録したユーザーはメールアドレスを確認するまでログインできず、ログインを試みると確認メールを送り直す
+// 確認メールのリンクは確認画面を表示するだけで、確認画面からの POST で確認済みになる
+func TestSignupRequiresVerification(t *testing.T) {
+	ts := newTestServer(t)
+	const email = "new@example.com"
+
+	res, body := ts.do(t, testRequest{method: http.MethodPost, path: "/signup", form: url.Values{
+		"name": {"new user"}, "email": {email}, "password": {testPassword},
+	}})
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, email+" に確認メールを送信しました") {
+		t.Fatalf("signup: status = %d, want the verify_sent page", res.StatusCode)
+	}
+	ts.mailLink(t, email, "/verify")
+
+	// 未確認のユーザーはログインできず、確認メールが送り直される
+	res, body = ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm(email, testPassword)})
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, email+" に確認メールを送信しました") {
+		t.Fatalf("unverified login: status = %d, Location = %q, want the verify_sent page", res.StatusCode, res.Header.Get("Location"))
+	}
+	if c := responseCookie(res, sessionCookieName); c != nil {
+		t.Errorf("unverified login set a session cookie: %+v", c)
+	}
+	if len(ts.mailer.sent) != 2 {
+		t.Fatalf("sent %d mails, want 2", len(ts.mailer.sent))
+	}
+	link := ts.mailLink(t, email, "/verify")
+	token := link.Query().Get("token")
+
+	// リンクを開いただけでは確認済みにならない
+	res, body = ts.do(t, testRequest{method: http.MethodGet, path: link.RequestURI()})
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, `name="token" value="`+token+`"`) {
+		t.Fatalf("verify form: status = %d, want a form with the token", res.StatusCode)
+	}
+	user, err := ts.store.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Verified() {
+		t.Fatal("user was verified by opening the link")
+	}
+
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/verify", form: url.Values{"token": {token}}})
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/login" {
+		t.Fatalf("verify: status = %d, Location = %q, want redirect to /login", res.StatusCode, res.Header.Get("Location"))
+	}
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/authenticate", form: loginForm(email, testPassword)})
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/todos" {
+		t.Fatalf("verified login: status = %d, Location = %q, want redirect to /todos", res.StatusCode, res.Header.Get("Location"))
+	}
+	if c := responseCookie(res, sessionCookieName); c == nil || c.Value == "" {
+		t.Error("verified login did not set a session cookie")
+	}
+
+	// 使用済みのリンクは使えない
+	res, _ = ts.do(t, testRequest{method: http.MethodPost, path: "/verify", form: url.Values{"token": {token}}})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("reused token: status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// 登録済みのメールアドレスでの登録は 409 を返し、入力値を残したフォームにエラーを表示する
+func TestSignupDuplicateEmail(t *testing.T) {
+	ts := newTestServer(t)
+	ts.newUser(t, "a@example.com")
+
+	res, body := ts.do(t, testRequest{method: http.MethodPost, path: "/signup", form: url.Values{
+		"name": {"another user"}, "email": {"a@example.com"}, "password": {"another password"},
+	}})
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+	for _, want := range []string{"このメールアドレスは既に登録されています", `value="another user"`, `value="a@example.com"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %s", want)
+		}
+	}
+	if len(ts.mailer.sent) != 0 {
+		t.Errorf("sent = %+v, want no mail", ts.mailer.sent)
+	}
+}
@@ -1,164 +1,317 @@
-// Package controllers provides HTTP handlers and server startup logic.
+// Package controllers provides HTTP handlers and routing.
 package controllers
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 	"todo-app/app/models"
+	"todo-app/app/validation"
 )
 
 // top ハンドラは、ルート ("/") への HTTP リクエストを処理
-// テンプレートを使用して HTML レスポンスを生成し、クライアントに返す
-func top(w http.ResponseWriter, r *http.Request) {
-	_, err := session(w, r)
-	if err != nil {
-		// generateHTML 関数を呼び出して、指定されたテンプレートを描画
-		generateHTML(w, nil, "layout", "public_navbar", "top")
-	} else {
-		http.Redirect(w, r, "/todos", http.StatusFound)
-	}
+// テンプレートを使用して HTML レスポンスを生成し、クライアントに返す（ログイン済みの場合は guestOnly がリダイレクトする）
+func (s *Server) top(w http.ResponseWriter, r *http.Request) {
+	// generateHTML 関数を呼び出して、指定されたテンプレートを描画
+	s.generateHTML(w, r, nil, "layout", "public_navbar", "top")
+}
+
+// indexData は index テンプレートに渡すデータ
+type indexData struct {
+	models.User
+	Filter  url.Values // 表示中の絞り込み・並び替えの条件（フォームの初期値に使う）
+	NextURL string     // 次のページのURL（最後のページでは空）
 }
 
 // index ハンドラは、ユーザーのTodoリストを表示する
-// セッションを確認し、認証済みであればユーザーのTodoを取得してテンプレートに渡す
-func index(w http.ResponseWriter, r *http.Request) {
-	log.Println("index handler started")
-	sess, err := session(w, r)
+// ログイン中のユーザー（requireLogin が設定）のTodoを取得してテンプレートに渡す
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	// クエリ文字列で絞り込み・並び替え・ページングの条件を指定する
+	filter := r.URL.Query()
+	q, err := parseTodoQuery(filter)
 	if err != nil {
-		log.Println("index handler: session error, redirecting to /:", err)
-		http.Redirect(w, r, "/", http.StatusFound)
-	} else {
-		log.Println("index handler: session found")
-		user, err := sess.GetUserBySession()
-		if err != nil {
-			log.Println("index handler: Error getting user by session:", err)
-		}
-		todos, _ := user.GetTodosByUser()
-		user.Todos = todos
-		log.Printf("index handler: User object before passing to template: %+v\n", user)
-		// generateHTML 関数を呼び出して、指定されたテンプレートを描画
-		generateHTML(w, user, "layout", "private_navbar", "index")
+		slog.InfoContext(r.Context(), "index: invalid query", "error", err)
+		s.renderErrorMessage(w, r, http.StatusBadRequest, "絞り込み・並び替えの条件が正しくありません。")
+		return
+	}
+	page, err := s.todos.ListTodos(r.Context(), user.ID, q)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		slog.InfoContext(r.Context(), "index: invalid cursor", "error", err)
+		s.renderErrorMessage(w, r, http.StatusBadRequest, "ページの指定が正しくありません。一覧の最初のページから開き直してください。")
+		return
 	}
+	if err != nil {
+		s.storeError(w, r, "index: error listing todos", err)
+		return
+	}
+	user.Todos = page.Todos
+	filter.Del("cursor")
+	slog.DebugContext(r.Context(), "index: rendering todos", "user_id", user.ID, "count", len(page.Todos))
+	// generateHTML 関数を呼び出して、指定されたテンプレートを描画
+	s.generateHTML(w, r, indexData{User: user, Filter: filter, NextURL: nextPageURL("/todos", filter, page.NextCursor)}, "layout", "private_navbar", "index")
 }
 
 // todoNew ハンドラは、新しいTodo作成フォームを表示する
-// 認証済みであればフォームページを表示する
-func todoNew(w http.ResponseWriter, r *http.Request) {
-	_, err := session(w, r)
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
-	} else {
-		// generateHTML 関数を呼び出して、指定されたテンプレートを描画
-		generateHTML(w, nil, "layout", "private_navbar", "todo_new")
-	}
+func (s *Server) todoNew(w http.ResponseWriter, r *http.Request) {
+	// generateHTML 関数を呼び出して、指定されたテンプレートを描画
+	s.generateHTML(w, r, newTodoFormData(models.Todo{}), "layout", "private_navbar", "todo_new")
 }
 
 // todoSave ハンドラは、新しいTodoの作成リクエストを処理する
 // フォームから内容を取得し、ユーザーに関連付けて保存後、一覧ページにリダイレクトする
-func todoSave(w http.ResponseWriter, r *http.Request) {
-	log.Println("todoSave handler started")
-	sess, err := session(w, r)
+func (s *Server) todoSave(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		log.Println("todoSave handler: session error, redirecting to /login:", err)
-		http.Redirect(w, r, "/login", http.StatusFound)
+		slog.WarnContext(r.Context(), "todoSave: form parse error", "error", err)
+		s.renderError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	log.Println("todoSave handler: session found, parsing form.")
-	err = r.ParseForm()
-	if err != nil {
-		log.Println("todoSave handler: Form parse error:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+	user := currentUser(r)
+	todo := models.Todo{UserID: user.ID}
+	if data := parseTodoForm(r, &todo); !data.Errors.Valid() {
+		// 入力エラーは入力値を残したままフォームを再表示する
+		slog.InfoContext(r.Context(), "todoSave: invalid form value", "errors", data.Errors.Error())
+		s.generateHTMLStatus(w, r, http.StatusUnprocessableEntity, data, "layout", "private_navbar", "todo_new")
 		return
 	}
 
-	log.Println("todoSave handler: Attempting to get user by session.")
-	user, err := sess.GetUserBySession()
-	if err != nil {
-		log.Println("todoSave handler: Error getting user by session:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	slog.DebugContext(r.Context(), "todoSave: creating todo", "user_id", user.ID)
+	if err := s.todos.CreateTodo(r.Context(), &todo); err != nil {
+		slog.ErrorContext(r.Context(), "todoSave: error creating todo", "error", err)
+		s.setFlash(w, flashError, "Todoを作成できませんでした。しばらくしてからもう一度お試しください")
+		http.Redirect(w, r, "/todos", http.StatusFound)
 		return
 	}
 
-	content := r.PostFormValue("content")
-	if content == "" {
-		log.Println("todoSave handler: Content is empty.")
-		http.Error(w, "Bad Request: Todo content cannot be empty", http.StatusBadRequest)
+	slog.InfoContext(r.Context(), "todoSave: todo created", "user_id", user.ID, "todo_id", todo.ID)
+	s.setFlash(w, flashSuccess, "Todoを作成しました")
+	http.Redirect(w, r, "/todos", http.StatusFound)
+}
+
+// todoEdit ハンドラは、既存のTodoの編集フォームを表示する（GET /todos/{id}/edit）
+// URLパスからTodo IDを取得し、ログイン中のユーザーが所有するTodoのみテンプレートに渡す
+func (s *Server) todoEdit(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.userTodo(w, r, currentUser(r))
+	if !ok {
 		return
 	}
+	s.generateHTML(w, r, newTodoFormData(t), "layout", "private_navbar", "todo_edit")
+}
 
-	log.Printf("todoSave handler: Creating todo for user %d with content: %s", user.ID, content)
-	if err := user.CreateTodo(content); err != nil {
-		log.Println("todoSave handler: Error creating todo:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// todoUpdate ハンドラは、既存のTodoの更新リクエストを処理する（POST /todos/{id}）
+// URLパスからTodo ID、フォームから更新内容を取得し、Todoを更新後、一覧ページにリダイレクトする
+func (s *Server) todoUpdate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.WarnContext(r.Context(), "todoUpdate: form parse error", "error", err)
+		s.renderError(w, r, http.StatusBadRequest)
 		return
 	}
-
-	log.Println("todoSave handler: Todo created successfully, redirecting to /todos.")
+	t, ok := s.userTodo(w, r, currentUser(r))
+	if !ok {
+		return
+	}
+	if data := parseTodoForm(r, &t); !data.Errors.Valid() {
+		// 入力エラーは入力値を残したままフォームを再表示する
+		slog.InfoContext(r.Context(), "todoUpdate: invalid form value", "errors", data.Errors.Error())
+		s.generateHTMLStatus(w, r, http.StatusUnprocessableEntity, data, "layout", "private_navbar", "todo_edit")
+		return
+	}
+	if err := s.todos.UpdateTodo(r.Context(), &t); err != nil {
+		slog.ErrorContext(r.Context(), "todoUpdate: error updating todo", "todo_id", t.ID, "error", err)
+		s.setFlash(w, flashError, "Todoを更新できませんでした。しばらくしてからもう一度お試しください")
+	} else {
+		s.setFlash(w, flashSuccess, "Todoを更新しました")
+	}
 	http.Redirect(w, r, "/todos", http.StatusFound)
 }
 
-// todoEdit ハンドラは、既存のTodoの編集フォームを表示する
-// URLパスからTodo IDを取得し、Todo情報を取得してテンプレートに渡す
-func todoEdit(w http.ResponseWriter, r *http.Request, id int) {
-	sess, err := session(w, r)
-	if err != nil {
-		http.Redirect(w, r, "/login", 302)
+// todoDelete ハンドラは、既存のTodoの削除リクエストを処理する（DELETE /todos/{id}）
+// URLパスからTodo IDを取得し、Todoを削除後、一覧ページにリダイレクトする
+// リダイレクトは 303 とし、DELETE のリクエストからも一覧を GET で表示させる
+func (s *Server) todoDelete(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	t, ok := s.userTodo(w, r, user)
+	if !ok {
+		return
+	}
+	if err := s.todos.DeleteTodo(r.Context(), user.ID, t.ID); err != nil {
+		slog.ErrorContext(r.Context(), "todoDelete: error deleting todo", "todo_id", t.ID, "error", err)
+		s.setFlash(w, flashError, "Todoを削除できませんでした。しばらくしてからもう一度お試しください")
 	} else {
-		_, err := sess.GetUserBySession()
-		if err != nil {
-			log.Println(err)
-		}
-		t, err := models.GetTodo(id)
-		if err != nil {
-			log.Println(err)
-		}
-		generateHTML(w, t, "layout", "private_navbar", "todo_edit")
+		s.setFlash(w, flashSuccess, "Todoを削除しました")
 	}
+	http.Redirect(w, r, "/todos", http.StatusSeeOther)
 }
 
-// todoUpdate ハンドラは、既存のTodoの更新リクエストを処理する
-// URLパスからTodo ID、フォームから更新内容を取得し、Todoを更新後、一覧ページにリダイレクトする
-func todoUpdate(w http.ResponseWriter, r *http.Request, id int) {
-	sess, err := session(w, r)
+// userTodo はログイン中のユーザーが所有するTodoをパスの {id} で取得する
+// IDが不正、存在しない、または他のユーザーのTodoの場合は 404 のエラーページを表示し、ok=false を返す
+func (s *Server) userTodo(w http.ResponseWriter, r *http.Request, user models.User) (todo models.Todo, ok bool) {
+	id, ok := pathID(r)
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return todo, false
+	}
+	todo, err := s.todos.GetTodo(r.Context(), user.ID, id)
 	if err != nil {
-		http.Redirect(w, r, "/login", 302)
-	} else {
-		err := r.ParseForm()
-		if err != nil {
-			log.Println(err)
-		}
-		user, err := sess.GetUserBySession()
+		s.storeError(w, r, "userTodo: error getting todo", err)
+		return todo, false
+	}
+	return todo, true
+}
+
+// dueAtLayout はフォームの期限入力（datetime-local）の書式
+const dueAtLayout = "2006-01-02T15:04"
+
+// todoFormData は todo_new / todo_edit テンプレートに渡すデータ
+// 入力エラー時は送信された値を残したままフォームを再表示する
+type todoFormData struct {
+	models.Todo                   // 入力中のTodo（編集時は保存済みの値）
+	DueAtInput  string            // 期限の入力値（不正な値もそのままフォームに戻す）
+	Errors      validation.Errors // 項目ごとの入力エラー
+}
+
+// newTodoFormData は保存済みのTodoからフォームの初期値を作る
+func newTodoFormData(todo models.Todo) todoFormData {
+	data := todoFormData{Todo: todo, Errors: validation.Errors{}}
+	if todo.DueAt != nil {
+		data.DueAtInput = todo.DueAt.Format(dueAtLayout)
+	}
+	return data
+}
+
+// parseTodoForm はフォームの内容・完了状態・期限・優先度をTodoに設定する
+// 期限が空の場合は未設定とし、入力エラーは返り値の Errors に項目ごとに記録する
+func parseTodoForm(r *http.Request, todo *models.Todo) todoFormData {
+	errs := validation.Todo(r.PostFormValue("content"))
+	todo.Content = r.PostFormValue("content")
+	todo.SetDone(r.PostFormValue("done") != "", time.Now())
+
+	due := r.PostFormValue("due_at")
+	todo.DueAt = nil
+	if due != "" {
+		dueAt, err := time.ParseInLocation(dueAtLayout, due, time.Local)
 		if err != nil {
-			log.Println(err)
+			errs.Add("due_at", "期限の形式が正しくありません")
+		} else {
+			todo.DueAt = &dueAt
 		}
-		content := r.PostFormValue("content")
-		t := &models.Todo{ID: id, Content: content, UserID: user.ID}
-		if err := t.UpdateTodo(); err != nil {
-			log.Println(err)
+	}
+
+	todo.Priority = models.PriorityNone
+	if p := r.PostFormValue("priority"); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil || !models.ValidPriority(priority) {
+			errs.Add("priority", "優先度を選択してください")
+		} else {
+			todo.Priority = priority
 		}
-		http.Redirect(w, r, "/todos", 302)
 	}
+	return todoFormData{Todo: *todo, DueAtInput: due, Errors: errs}
 }
 
-// todoDelete ハンドラは、既存のTodoの削除リクエストを処理する
-// URLパスからTodo IDを取得し、Todoを削除後、一覧ページにリダイレクトする
-func todoDelete(w http.ResponseWriter, r *http.Request, id int) {
-	sess, err := session(w, r)
+// queryDateLayout は一覧の絞り込みで指定する日付の書式
+const queryDateLayout = "2006-01-02"
+
+// parseQueryDate は日付（YYYY-MM-DD）をその日の0時として解析する
+// 空の場合は nil を返し、不正な値の場合はエラーを返す
+func parseQueryDate(values url.Values, key string) (*time.Time, error) {
+	v := values.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(queryDateLayout, v, time.Local)
 	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
-	} else {
-		_, err := sess.GetUserBySession()
-		if err != nil {
-			log.Println(err)
+		return nil, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return &t, nil
+}
+
+// parseTodoQuery はクエリ文字列からTodo一覧の絞り込み・並び替え・ページングの条件を作る
+// status（旧形式の view も可）, due_from, due_to, priority, q, created_from, created_to,
+// sort, order, limit, cursor を受け付け、不正な値の場合はエラーを返す
+// 日付の終了（due_to, created_to）はその日を含むよう翌日の0時より前として扱う
+func parseTodoQuery(values url.Values) (q models.TodoQuery, err error) {
+	q.Status = values.Get("status")
+	if q.Status == "" {
+		q.Status = values.Get("view")
+	}
+	switch q.Status {
+	case "", models.TodoViewAll, models.TodoViewOpen, models.TodoViewCompleted, models.TodoViewOverdue:
+	default:
+		return q, fmt.Errorf("invalid status %q", q.Status)
+	}
+
+	if q.DueFrom, err = parseQueryDate(values, "due_from"); err != nil {
+		return q, err
+	}
+	if q.DueTo, err = parseQueryDate(values, "due_to"); err != nil {
+		return q, err
+	}
+	if q.CreatedFrom, err = parseQueryDate(values, "created_from"); err != nil {
+		return q, err
+	}
+	if q.CreatedTo, err = parseQueryDate(values, "created_to"); err != nil {
+		return q, err
+	}
+	for _, to := range []*time.Time{q.DueTo, q.CreatedTo} {
+		if to != nil {
+			*to = to.AddDate(0, 0, 1)
 		}
-		t, err := models.GetTodo(id)
-		if err != nil {
-			log.Println(err)
+	}
+
+	if p := values.Get("priority"); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil || !models.ValidPriority(priority) {
+			return q, fmt.Errorf("invalid priority %q", p)
 		}
-		if err := t.DeleteTodo(); err != nil {
-			log.Println(err)
+		q.Priority = &priority
+	}
+
+	q.Contains = values.Get("q")
+
+	q.Sort = values.Get("sort")
+	switch q.Sort {
+	case "", models.TodoSortCreatedAt, models.TodoSortDueAt, models.TodoSortPriority:
+	default:
+		return q, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q", order)
+	}
+
+	if l := values.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", l)
 		}
-		http.Redirect(w, r, "/todos", http.StatusFound)
+		q.Limit = limit
+	}
+
+	q.Cursor = values.Get("cursor")
+	return q, nil
+}
+
+// nextPageURL は現在の絞り込み条件のまま、次のページのカーソルを指定したURLを返す
+// 次のページがない場合は空文字を返す
+func nextPageURL(path string, values url.Values, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	next := url.Values{}
+	for k, v := range values {
+		next[k] = v
 	}
+	next.Set("cursor", cursor)
+	return path + "?" + next.Encode()
 }
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// 他のユーザーの Todo は、画面でも API でも存在しない場合と同じく 404 を返し、変更しない
+func TestTodoOwnedByOtherUser(t *testing.T) {
+	ts := newTestServer(t)
+	owner, ownerSess := ts.newUser(t, "owner@example.com")
+	_, otherSess := ts.newUser(t, "other@example.com")
+	todo := ts.newTodo(t, owner, "owner's todo")
+	path := fmt.Sprintf("/todos/%d", todo.ID)
+	apiPath := fmt.Sprintf("/api/v1/todos/%d", todo.ID)
+
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{"edit", testRequest{method: http.MethodGet, path: path + "/edit"}},
+		{"update", testRequest{method: http.MethodPost, path: path, form: url.Values{"content": {"updated"}}}},
+		{"delete", testRequest{method: http.MethodDelete, path: path, header: map[string]string{csrfHeaderName: testCSRFToken}}},
+		{"delete from form", testRequest{method: http.MethodPost, path: path, form: url.Values{methodOverrideField: {http.MethodDelete}}}},
+		{"api get", testRequest{method: http.MethodGet, path: apiPath}},
+		{"api update", testRequest{method: http.MethodPut, path: apiPath, json: `{"content":"updated"}`}},
+		{"api delete", testRequest{method: http.MethodDelete, path: apiPath}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.session = &otherSess
+			res, _ := ts.do(t, tt.req)
+			if res.StatusCode != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+			}
+			got, err := ts.store.GetTodo(context.Background(), owner.ID, todo.ID)
+			if err != nil {
+				t.Fatalf("owner's todo: %v", err)
+			}
+			if got.Content != todo.Content {
+				t.Errorf("content = %q, want %q", got.Content, todo.Content)
+			}
+		})
+	}
+
+	// 所有者は同じリクエストで操作できる
+	res, _ := ts.do(t, testRequest{method: http.MethodGet, path: path + "/edit", session: &ownerSess})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("owner edit: status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	res, _ = ts.do(t, testRequest{method: http.MethodGet, path: apiPath, session: &ownerSess})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("owner api get: status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	res, _ = ts.do(t, testRequest{method: http.MethodDelete, path: path, session: &ownerSess, header: map[string]string{csrfHeaderName: testCSRFToken}})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("owner delete: status = %d, want %d", res.StatusCode, http.StatusSeeOther)
+	}
+	if _, err := ts.store.GetTodo(context.Background(), owner.ID, todo.ID); err == nil {
+		t.Error("owner's todo was not deleted")
+	}
+}
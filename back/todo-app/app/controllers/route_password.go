@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"todo-app/app/models"
+	"todo-app/app/validation"
+)
+
+// パスワード再設定のハンドラ
+// 1. /password/forgot でメールアドレスを受け取り、再設定用のリンクをメールで送る
+// 2. /password/reset でリンクのトークンを検証し、新しいパスワードを設定する
+// トークンは1回限り・有効期限付きで、DBにはハッシュのみを保存する
+
+// forgotPasswordData は forgot_password テンプレートに渡すデータ
+type forgotPasswordData struct {
+	Sent bool // 再設定メールの送信を受け付けたか
+}
+
+// resetPasswordData は reset_password テンプレートに渡すデータ
+type resetPasswordData struct {
+	Token  string            // メールのリンクに含まれていたトークン
+	Errors validation.Errors // 項目ごとの入力エラー
+}
+
+// forgotPasswordForm ハンドラ: メールアドレスの入力フォームを表示する（GET /password/forgot）
+func (s *Server) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, forgotPasswordData{}, "layout", "public_navbar", "forgot_password")
+}
+
+// forgotPassword ハンドラ: 再設定用のリンクをメールで送る（POST /password/forgot）
+// メールアドレスが登録されているかどうかは画面に出さない
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := r.PostFormValue("email")
+	user, err := s.users.GetUserByEmail(r.Context(), email)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		s.storeError(w, r, "forgotPassword: error looking up user", err)
+		return
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "forgotPassword: no user for email", "email", email, "error", err)
+	} else {
+		s.sendResetMail(r.Context(), user)
+	}
+	s.generateHTML(w, r, forgotPasswordData{Sent: true}, "layout", "public_navbar", "forgot_password")
+}
+
+// sendResetMail はパスワード再設定用のリンクを記載したメールを送る
+func (s *Server) sendResetMail(ctx context.Context, user models.User) {
+	s.sendTokenMail(ctx, user, tokenMail{
+		purpose: models.TokenPurposeReset,
+		ttl:     s.config.ResetTokenTTL,
+		path:    "/password/reset",
+		subject: "パスワードの再設定",
+		body: func(link string, ttl time.Duration) string {
+			return fmt.Sprintf("パスワードの再設定を受け付けました。\n"+
+				"以下のリンクから新しいパスワードを設定してください（有効期限: %s、1回のみ有効）。\n\n%s\n\n"+
+				"お心当たりがない場合は、このメールを破棄してください。\n", ttl, link)
+		},
+	})
+}
+
+// resetPasswordForm ハンドラ: 新しいパスワードの入力フォームを表示する（GET /password/reset）
+func (s *Server) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	s.generateHTML(w, r, resetPasswordData{Token: r.URL.Query().Get("token")}, "layout", "public_navbar", "reset_password")
+}
+
+// resetPassword ハンドラ: トークンを検証してパスワードを更新する（POST /password/reset）
+// 更新の前にユーザーのセッションをすべて削除し、他の端末のログインも無効にする
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	data := resetPasswordData{Token: r.PostFormValue("token")}
+	password := r.PostFormValue("password")
+	// トークンを消費する前に入力を検証し、入力ミスでリンクが使えなくならないようにする
+	data.Errors = validation.NewPassword(password, r.PostFormValue("password_confirmation"))
+	if !data.Errors.Valid() {
+		s.generateHTMLStatus(w, r, http.StatusUnprocessableEntity, data, "layout", "public_navbar", "reset_password")
+		return
+	}
+
+	hashed, err := models.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resetPassword: password hashing error", "error", err)
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
+	}
+	// 失敗しても同じリンクでやり直せるよう、セッションの削除・パスワードの更新を終えてからトークンを使用済みにする
+	userID, err := s.tokens.LookupUserToken(r.Context(), models.TokenPurposeReset, data.Token)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		s.storeError(w, r, "resetPassword: error looking up token", err)
+		return
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "resetPassword: invalid or expired token", "error", err)
+		s.renderErrorMessage(w, r, http.StatusBadRequest, "パスワード再設定のリンクが正しくないか、有効期限が切れています。もう一度、再設定の手続きを行ってください。")
+		return
+	}
+	// 他の端末のログインを無効にできなかった場合は、パスワードを変更しない
+	if err := s.sessions.DeleteSessionsByUser(r.Context(), userID); err != nil {
+		s.storeError(w, r, "resetPassword: error deleting sessions", err)
+		return
+	}
+	if err := s.users.UpdatePassword(r.Context(), userID, hashed); err != nil {
+		s.storeError(w, r, "resetPassword: error updating password", err)
+		return
+	}
+	// 確認してから使用済みにするまでの間に期限が切れた・同じリンクで再設定された場合も、パスワードは更新済みのため完了とする
+	if _, err := s.tokens.ConsumeUserToken(r.Context(), models.TokenPurposeReset, data.Token); err != nil && !errors.Is(err, models.ErrNotFound) {
+		s.storeError(w, r, "resetPassword: error consuming token", err)
+		return
+	}
+	// 再設定したアカウントのロックも解除する
+	if user, err := s.users.GetUser(r.Context(), userID); err == nil {
+		s.resetLoginFailures(r.Context(), user.Email)
+	}
+	slog.InfoContext(r.Context(), "resetPassword: password reset; all sessions invalidated", "user_id", userID)
+
+	s.clearSessionCookie(w)
+	s.rotateCSRFToken(w, r)
+	s.setFlash(w, flashSuccess, "パスワードを再設定しました。新しいパスワードでログインしてください")
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
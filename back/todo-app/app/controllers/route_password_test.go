@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"todo-app/app/models"
+)
+
+// 再設定の途中でストアの操作に失敗した場合はエラーページを返し、同じリンクでやり直せる
+// やり直して完了すると、パスワードが変わり、以前のセッションは無効になり、リンクは使えなくなる
+func TestResetPasswordRetryAfterStoreFailure(t *testing.T) {
+	for _, op := range []string{"DeleteSessionsByUser", "UpdatePassword", "ConsumeUserToken"} {
+		t.Run(op, func(t *testing.T) {
+			unavailable := &models.StoreError{Op: op, Kind: models.ErrUnavailable, Err: context.DeadlineExceeded}
+			fail := map[string]error{op: unavailable}
+			ts := newFailingTestServer(t, fail)
+			user, sess := ts.newUser(t, "a@example.com")
+			token, err := ts.store.CreateUserToken(context.Background(), user.ID, models.TokenPurposeReset, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reset := func() *http.Response {
+				res, _ := ts.do(t, testRequest{method: http.MethodPost, path: "/password/reset", form: url.Values{
+					"token":                 {token},
+					"password":              {"new password 123"},
+					"password_confirmation": {"new password 123"},
+				}})
+				return res
+			}
+
+			res := reset()
+			if res.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusServiceUnavailable)
+			}
+			if loc := res.Header.Get("Location"); loc != "" {
+				t.Errorf("Location = %q, want no redirect", loc)
+			}
+
+			// 障害が解消したら同じリンクで再設定を完了できる
+			delete(fail, op)
+			res = reset()
+			if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/login" {
+				t.Fatalf("retry: status = %d, Location = %q, want redirect to /login", res.StatusCode, res.Header.Get("Location"))
+			}
+			stored, err := ts.store.GetUser(context.Background(), user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !models.Authenticate(context.Background(), ts.store, &stored, "new password 123") {
+				t.Error("new password does not authenticate")
+			}
+			if _, err := ts.store.CheckSession(context.Background(), sess.UUID); err == nil {
+				t.Error("old session is still valid")
+			}
+			if _, err := ts.store.LookupUserToken(context.Background(), models.TokenPurposeReset, token); err == nil {
+				t.Error("token can still be used")
+			}
+		})
+	}
+}
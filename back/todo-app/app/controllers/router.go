@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"todo-app/app/models"
+)
+
+// ルーティング
+// Go 1.22 以降の ServeMux のパターン（"GET /todos/{id}/edit" のようなメソッドとパスパラメーター）でルートを登録する
+// 一致するルートがない場合は 404、パスは一致するがメソッドが違う場合は Allow ヘッダー付きの 405 を
+// 画面にはエラーページ、API には JSON で返す
+// ログインが必要なルートなど、同じミドルウェアを使うルートは routes.group でまとめる
+
+// middleware は http.Handler を包んで前後に処理を加える
+type middleware func(http.Handler) http.Handler
+
+// routes はルートを登録する ServeMux と、登録するハンドラに適用するミドルウェアの組
+type routes struct {
+	mux         *http.ServeMux
+	middlewares []middleware
+}
+
+// group は現在のミドルウェアに mws を加えたルートのグループを返す
+// ミドルウェアは加えた順に外側から適用する
+func (g *routes) group(mws ...middleware) *routes {
+	return &routes{mux: g.mux, middlewares: append(slices.Clone(g.middlewares), mws...)}
+}
+
+// handle はグループのミドルウェアで包んだハンドラを pattern に登録する
+func (g *routes) handle(pattern string, h http.Handler) {
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		h = g.middlewares[i](h)
+	}
+	g.mux.Handle(pattern, h)
+}
+
+// handleFunc はグループのミドルウェアで包んだハンドラ関数を pattern に登録する
+func (g *routes) handleFunc(pattern string, h http.HandlerFunc) {
+	g.handle(pattern, h)
+}
+
+// userContextKey はリクエストのコンテキストにログイン中のユーザーを保存するためのキー
+type userContextKey struct{}
+
+// currentUser は requireLogin・requireAPIUser が保存したログイン中のユーザーを返す
+func currentUser(r *http.Request) models.User {
+	user, _ := r.Context().Value(userContextKey{}).(models.User)
+	return user
+}
+
+// withUser はユーザーを保存したリクエストを返す
+func withUser(r *http.Request, user models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
+
+// requireLogin はログイン中のユーザーをコンテキストに保存してから次のハンドラを呼び出すミドルウェア
+// 未ログインの場合はログイン画面へリダイレクトし、DB の障害などはエラーページを表示する
+func (s *Server) requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, err := s.session(w, r)
+		if errors.Is(err, models.ErrUnavailable) {
+			s.storeError(w, r, "requireLogin: error checking session", err)
+			return
+		}
+		if err != nil {
+			slog.DebugContext(r.Context(), "requireLogin: no valid session; redirecting to /login", "error", err)
+			s.setFlash(w, flashInfo, loginRequiredMessage)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		user, err := s.sessionUser(r.Context(), sess)
+		if err != nil {
+			s.sessionUserError(w, r, "requireLogin: error getting user by session", err)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user))
+	})
+}
+
+// requireAPIUser は requireLogin の JSON API 版
+// 未ログインの場合は 401 を返す
+func (s *Server) requireAPIUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, err := s.session(w, r)
+		if errors.Is(err, models.ErrUnavailable) {
+			apiStoreError(w, r, "requireAPIUser: error checking session", err)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		user, err := s.sessionUser(r.Context(), sess)
+		if errors.Is(err, models.ErrNotFound) {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if err != nil {
+			apiStoreError(w, r, "requireAPIUser: error getting user by session", err)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user))
+	})
+}
+
+// guestOnly はログイン済みの場合に Todo 一覧へリダイレクトするミドルウェア
+// トップ・ログイン・サインアップの画面に使う
+func (s *Server) guestOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.session(w, r); err == nil {
+			http.Redirect(w, r, "/todos", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodOverrideField はフォームから DELETE を送るための項目名
+// HTML のフォームは GET と POST しか送れないため、POST の _method=DELETE で削除を指定する
+const methodOverrideField = "_method"
+
+// methodOverride は POST のフォームの _method が DELETE の場合にリクエストのメソッドを DELETE にするミドルウェア
+// ルートの照合より前に置く（DELETE も状態を変更するメソッドとして csrf でトークンを検証する）
+// JSON API（/api/）は CSRF の検証の対象外のため置き換えない（フォームから API の DELETE を送れないようにする）
+func methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !strings.HasPrefix(r.URL.Path, "/api/") && strings.EqualFold(r.PostFormValue(methodOverrideField), http.MethodDelete) {
+			r.Method = http.MethodDelete
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathID はパスパラメーター {id} を正の整数として返す
+func pathID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	return id, err == nil && id > 0
+}
+
+// jsonRoute は JSON でエラーを返すパス（JSON API と /healthz）かを返す
+func jsonRoute(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/healthz"
+}
+
+// allowRecorder は ServeMux が書き込む 404・405 のステータスと Allow ヘッダーだけを記録する
+type allowRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *allowRecorder) Header() http.Header         { return rec.header }
+func (rec *allowRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (rec *allowRecorder) WriteHeader(status int)      { rec.status = status }
+
+// withFallback は一致するルートがないリクエストに 404・405 のエラーページ（API は JSON）を返す
+// 一致する場合は matched（CSRF の検証を含む mux）を呼び出す
+// ルートの照合を CSRF の検証より先に行い、存在しないパスやメソッドには常に 404・405 を返す
+func (s *Server) withFallback(mux *http.ServeMux, matched http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			matched.ServeHTTP(w, r)
+			return
+		}
+
+		// ServeMux の既定の応答（text/plain）から、ステータスと許可されたメソッドだけを取り出す
+		rec := &allowRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		status := http.StatusNotFound
+		if rec.status == http.StatusMethodNotAllowed {
+			status = http.StatusMethodNotAllowed
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+		}
+		slog.DebugContext(r.Context(), "router: no matching route", "method", r.Method, "path", r.URL.Path, "status", status)
+
+		if jsonRoute(r) {
+			message := "not found"
+			if status == http.StatusMethodNotAllowed {
+				message = "method not allowed"
+			}
+			writeJSONError(w, status, message)
+			return
+		}
+		// エラーページのナビゲーションバー（ログアウトのフォーム）で使うトークンを用意する
+		r, err := s.withCSRFToken(w, r, cookieCSRFToken(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "router: error generating csrf token", "error", err)
+		}
+		s.renderError(w, r, status)
+	})
+}
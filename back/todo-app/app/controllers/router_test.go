@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// 一致するルートがないリクエストは、CSRF トークンの有無によらず 404・405 を返す
+func TestRouterFallbackBeforeCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	noToken := ""
+
+	tests := []struct {
+		name   string
+		req    testRequest
+		status int
+		allow  string
+	}{
+		{"PUT to GET-only page", testRequest{method: http.MethodPut, path: "/login", csrf: &noToken}, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"POST to GET-only page", testRequest{method: http.MethodPost, path: "/login", form: url.Values{csrfFieldName: {"wrong"}}, csrf: &noToken}, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"DELETE to unknown path", testRequest{method: http.MethodDelete, path: "/nope", csrf: &noToken}, http.StatusNotFound, ""},
+		{"GET to POST-only page", testRequest{method: http.MethodGet, path: "/logout"}, http.StatusMethodNotAllowed, "POST"},
+		{"matched route still checks CSRF", testRequest{method: http.MethodPost, path: "/logout", csrf: &noToken}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _ := ts.do(t, tt.req)
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			if got := res.Header.Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
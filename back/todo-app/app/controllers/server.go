@@ -1,118 +1,251 @@
-// Package controllers provides HTTP handlers and server startup logic.
+// Package controllers provides HTTP handlers and routing.
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
-	"regexp"
-	"strconv"
+	"time"
+	"todo-app/app/mail"
 	"todo-app/app/models"
 	"todo-app/config"
 )
 
-// generateHTML は指定されたテンプレートファイルをパースし、データを適用して HTTP レスポンスライターに書き込む
-func generateHTML(w http.ResponseWriter, data interface{}, filenames ...string) {
-	var files []string
-	for _, file := range filenames {
-		// テンプレートファイルのパスを app/views/templates/ ディレクトリからの相対パスとして構築
-		files = append(files, fmt.Sprintf("app/views/templates/%s.html", file))
+// Server はHTTPハンドラが利用する依存関係をまとめた構造体
+// ストアはパッケージのグローバル変数ではなく NewServer で注入する
+type Server struct {
+	config      config.ConfigList
+	users       models.UserStore
+	todos       models.TodoStore
+	sessions    models.SessionStore
+	tokens      models.UserTokenStore
+	health      models.HealthStore
+	limiter     models.LoginLimiter
+	mailer      mail.Mailer
+	policy      models.SessionPolicy
+	loginPolicy models.LoginPolicy
+	templates   *templateCache // パース済みのテンプレート
+	static      fs.FS          // /static/ で配信する静的ファイル
+	flashKey    []byte         // フラッシュメッセージのクッキーの署名に使う鍵
+}
+
+// NewServer は設定 c とストア・データベースの状態の確認・ログイン試行の制限・メール送信を受け取り Server を生成する
+// セッションの有効期限は c の [session] セクション、ログイン試行の制限は [login] セクションから決める
+// テンプレートと静的ファイルはバイナリに埋め込んだもの（開発モードではディスク上のもの）を使う
+func NewServer(c config.ConfigList, users models.UserStore, todos models.TodoStore, sessions models.SessionStore,
+	tokens models.UserTokenStore, health models.HealthStore, limiter models.LoginLimiter, mailer mail.Mailer) *Server {
+	templates, static := viewFS(c)
+	return &Server{
+		config:   c,
+		users:    users,
+		todos:    todos,
+		sessions: sessions,
+		tokens:   tokens,
+		health:   health,
+		limiter:  limiter,
+		mailer:   mailer,
+		policy: models.SessionPolicy{
+			Lifetime:    c.SessionLifetime,
+			IdleTimeout: c.SessionIdleTimeout,
+		},
+		loginPolicy: LoginPolicy(c),
+		templates:   newTemplateCache(templates, c.Dev),
+		static:      static,
+		flashKey:    newFlashKey(c.Secret),
+	}
+}
+
+// LoginPolicy は設定 c の [login] セクションからログイン試行の制限の設定を作る
+func LoginPolicy(c config.ConfigList) models.LoginPolicy {
+	return models.LoginPolicy{
+		MaxEmailFailures: c.LoginMaxEmailFailures,
+		MaxIPFailures:    c.LoginMaxIPFailures,
+		Window:           c.LoginWindow,
+		Lockout:          c.LoginLockout,
+		BaseDelay:        c.LoginBaseDelay,
+		MaxDelay:         c.LoginMaxDelay,
 	}
+}
 
-	log.Println("generateHTML: Attempting to parse template files:", files)
+// NewLoginLimiter は設定 c の [login] limiter に従って LoginLimiter を生成する
+// db の場合は login_attempts テーブルに記録し、memory の場合はプロセス内のみで記録する
+func NewLoginLimiter(c config.ConfigList, store *models.SQLStore) models.LoginLimiter {
+	if c.LoginLimiter == "db" {
+		return models.NewSQLLoginLimiter(store, LoginPolicy(c))
+	}
+	return models.NewMemoryLoginLimiter(LoginPolicy(c))
+}
+
+// generateHTML は指定されたテンプレートの組み合わせにデータを適用して HTTP レスポンスライターに書き込む
+// テンプレートはキャッシュから取得し、リクエストごとの関数を登録した複製を実行する
+// テンプレートからは csrfField（フォームに埋め込む hidden 項目）と csrfToken でリクエストのCSRFトークンを、
+// flash でリダイレクト前に設定されたメッセージ（表示後は消える）を参照できる
+func (s *Server) generateHTML(w http.ResponseWriter, r *http.Request, data interface{}, filenames ...string) {
+	s.generateHTMLStatus(w, r, http.StatusOK, data, filenames...)
+}
 
-	// テンプレートファイルをパース
-	// ParseFiles は複数のファイルを読み込み、定義されたテンプレートのセットを作成
-	templates, err := template.ParseFiles(files...)
+// generateHTMLStatus は generateHTML と同じくテンプレートを書き込み、ステータスを status にする
+// エラーページや入力エラーのフォームの再表示に使う
+// フラッシュメッセージを消すクッキーを設定してからステータスを書き込むため、呼び出し元で先に WriteHeader を呼ばない
+func (s *Server) generateHTMLStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}, filenames ...string) {
+	cached, err := s.templates.lookup(filenames...)
 	if err != nil {
-		log.Printf("generateHTML: Template parsing error: %v", err)
+		slog.ErrorContext(r.Context(), "generateHTML: template parsing error", "templates", filenames, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	templates, err := cached.Clone()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "generateHTML: template clone error", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	message := s.popFlash(w, r)
+	templates.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML { return csrfField(r) },
+		"csrfToken": func() string { return csrfToken(r) },
+		"flash":     func() *flash { return message },
+	})
+
+	w.WriteHeader(status)
 
-	log.Println("generateHTML: Template parsing successful. Executing template with data.")
 	// レイアウトテンプレートを基にデータを適用し、レスポンスライターに書き出し
 	// ここで "layout" という名前のテンプレートがテンプレートセット内に存在する必要
 	err = templates.ExecuteTemplate(w, "layout", data)
 	if err != nil {
-		log.Printf("generateHTML: Template execution error: %v", err)
+		slog.ErrorContext(r.Context(), "generateHTML: template execution error", "templates", filenames, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	log.Println("generateHTML: Template executed successfully.")
 }
 
-func session(w http.ResponseWriter, r *http.Request) (sess models.Session, err error) {
-	cookie, err := r.Cookie("__cookie__")
+// sessionCookieName はセッションUUIDを保持するクッキー名
+const sessionCookieName = "__cookie__"
+
+// setSessionCookie はセッションの有効期限に合わせたセッションクッキーを設定する
+func (s *Server) setSessionCookie(w http.ResponseWriter, sess models.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sess.UUID,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		MaxAge:   int(time.Until(sess.ExpiresAt).Seconds()),
+		Secure:   s.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie はセッションクッキーを無効化する（MaxAge=-1で即時削除）
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   s.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// session はクッキーのセッションUUIDを検証し、有効なセッションを返す
+// 有効なセッションの場合は有効期限を延長し、クッキーも合わせて更新する
+func (s *Server) session(w http.ResponseWriter, r *http.Request) (sess models.Session, err error) {
+	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil {
-		sess = models.Session{UUID: cookie.Value}
-		if ok, _ := sess.CheckSession(); !ok {
-			err = fmt.Errorf("Invalid session")
+		sess, err = s.sessions.CheckSession(r.Context(), cookie.Value)
+		if err != nil {
+			// 呼び出し元が DB の障害（models.ErrUnavailable）を判定できるよう元のエラーを含める
+			err = fmt.Errorf("invalid session: %w", err)
+			return sess, err
+		}
+		// 有効期限の延長に失敗してもリクエスト自体は継続する
+		expiresAt := s.policy.Expiry(sess.CreatedAt, time.Now())
+		if err := s.sessions.TouchSession(r.Context(), &sess, expiresAt); err != nil {
+			slog.ErrorContext(r.Context(), "session: error extending session", "error", err)
 		}
+		s.setSessionCookie(w, sess)
 	}
 	return sess, err
 }
 
-var validPath = regexp.MustCompile("^/todos/(edit|update|delete)/([0-9]+)$")
-
-// parseURL は、URLパスからIDを抽出し、抽出したIDとHTTPレスポンスライター、リクエストオブジェクトを指定されたハンドラ関数に渡す
-// パスが正規表現に一致しない場合は 404 Not Found を返す
-func parseURL(fn func(http.ResponseWriter, *http.Request, int)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// /todos/edit/1 のようなパスを想定
-		q := validPath.FindStringSubmatch(r.URL.Path)
-		if q == nil {
-			http.NotFound(w, r)
-			return
-		}
-		id, err := strconv.Atoi(q[2])
-		if err != nil {
-			http.NotFound(w, r)
-			return
-		}
-
-		fn(w, r, id)
-	}
+// sessionUser はセッションに紐づくユーザー情報を取得する
+// テンプレートやログに渡るため、パスワードハッシュは空にして返す
+func (s *Server) sessionUser(ctx context.Context, sess models.Session) (user models.User, err error) {
+	user, err = s.users.GetUser(ctx, sess.UserID)
+	user.PassWord = ""
+	return user, err
 }
 
-// StartMainServer はアプリケーションの Web サーバーを起動し、ルーティングを設定する
-func StartMainServer() error {
+// Handler はルーティングを設定した http.Handler を返す
+// ルートはメソッドとパスのパターンで登録し、ログインが必要なルートは requireLogin のグループにまとめる
+// 全体を methodOverride・csrf・requestLogger ミドルウェアで包む
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	root := &routes{mux: mux}
 
 	// 静的ファイルを提供するためのファイルサーバーを設定
-	files := http.FileServer(http.Dir(config.Config.Static))
-
-	// "/static/" パスへのリクエストをファイルサーバーで処理するように設定
-	http.Handle("/static/", http.StripPrefix("/static/", files))
-
-	// ルート ("/") へのリクエストを top ハンドラ関数で処理するように設定
-	http.HandleFunc("/", top)
-
-	// ルート ("/signup") へのリクエストを signup ハンドラ関数で処理するように設定
-	http.HandleFunc("/signup", signup)
-
-	// ルート ("/login") へのリクエストを login ハンドラ関数で処理するように設定
-	http.HandleFunc("/login", login)
-
-	// ルート ("/authenticate") へのリクエストを authenticate ハンドラ関数で処理するように設定
-	http.HandleFunc("/authenticate", authenticate)
-
-	http.HandleFunc("/logout", logout)
-
-	http.HandleFunc("/todos", index)
-
-	http.HandleFunc("/todos/new", todoNew)
-
-	http.HandleFunc("/todos/save", todoSave)
-
-	// IDを含む /todos/update/{id} 形式のパスを parseURL 経由で todoUpdate ハンドラにルーティング
-	http.HandleFunc("/todos/update", parseURL(todoUpdate))
-	// IDを含む /todos/edit/{id} 形式のパスを parseURL 経由で todoEdit ハンドラにルーティング
-	http.HandleFunc("/todos/edit", parseURL(todoEdit))
-	// IDを含む /todos/delete/{id} 形式のパスを parseURL 経由で todoDelete ハンドラにルーティング
-	http.HandleFunc("/todos/delete", parseURL(todoDelete))
+	files := http.FileServer(http.FS(s.static))
+	root.handle("GET /static/", http.StripPrefix("/static/", files))
+
+	// ログイン前の画面（ログイン済みなら Todo 一覧へリダイレクト）
+	guest := root.group(s.guestOnly)
+	guest.handleFunc("GET /{$}", s.top)
+	guest.handleFunc("GET /signup", s.signupForm)
+	guest.handleFunc("GET /login", s.login)
+
+	// サインアップ・ログイン・ログアウト
+	root.handleFunc("POST /signup", s.signup)
+	root.handleFunc("POST /authenticate", s.authenticate)
+	root.handleFunc("POST /logout", s.logout)
+
+	// メールアドレス確認メールのリンク先（GET: 確認画面、POST: 確認済みにする）
+	root.handleFunc("GET /verify", s.verifyForm)
+	root.handleFunc("POST /verify", s.verify)
+
+	// ロック解除メールのリンク先（GET: 確認画面、POST: 解除）
+	root.handleFunc("GET /unlock", s.unlockForm)
+	root.handleFunc("POST /unlock", s.unlock)
+
+	// パスワード再設定（GET: フォーム、POST: メール送信・パスワード更新）
+	root.handleFunc("GET /password/forgot", s.forgotPasswordForm)
+	root.handleFunc("POST /password/forgot", s.forgotPassword)
+	root.handleFunc("GET /password/reset", s.resetPasswordForm)
+	root.handleFunc("POST /password/reset", s.resetPassword)
+
+	// Todo の画面（ログインが必要）
+	// フォームからの削除は POST の _method=DELETE で DELETE として扱う（methodOverride）
+	todos := root.group(s.requireLogin)
+	todos.handleFunc("GET /todos", s.index)
+	todos.handleFunc("GET /todos/new", s.todoNew)
+	todos.handleFunc("POST /todos", s.todoSave)
+	todos.handleFunc("GET /todos/{id}/edit", s.todoEdit)
+	todos.handleFunc("POST /todos/{id}", s.todoUpdate)
+	todos.handleFunc("DELETE /todos/{id}", s.todoDelete)
+
+	// データベースの状態とコネクションプールの統計（ヘルスチェック・診断用、GET は HEAD も受け付ける）
+	root.handleFunc("GET /healthz", s.healthz)
+
+	// JSON API (/api/v1/todos)（ログインが必要）
+	api := root.group(s.requireAPIUser)
+	api.handleFunc("GET /api/v1/todos", s.apiListTodos)
+	api.handleFunc("POST /api/v1/todos", s.apiCreateTodo)
+	api.handleFunc("GET /api/v1/todos/{id}", s.apiGetTodo)
+	api.handleFunc("PUT /api/v1/todos/{id}", s.apiUpdateTodo)
+	api.handleFunc("DELETE /api/v1/todos/{id}", s.apiDeleteTodo)
+
+	// ルートを照合してから、状態を変更するリクエストはすべてCSRFトークンを検証する
+	// リクエストIDの発行とアクセスログの出力は最も外側で行い、CSRF で拒否したリクエストも記録する
+	return requestLogger(methodOverride(s.withFallback(mux, s.csrf(mux))))
+}
 
-	// 指定されたポートで HTTP リクエストのリスニングを開始する
-	log.Printf("Starting server on port %s...", config.Config.Port) // サーバー起動ログを追加
-	return http.ListenAndServe(":"+config.Config.Port, nil)
+// Preload はテンプレートをすべてパースしてキャッシュする（誤りがあればエラーを返す）
+// サーバーを起動する前に呼び出し、テンプレートの誤りを起動時に検出する
+func (s *Server) Preload() error {
+	return s.templates.preload()
 }
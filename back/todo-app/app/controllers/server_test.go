@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/app/mail"
+	"todo-app/app/models"
+	"todo-app/config"
+)
+
+// testCSRFToken はテストのリクエストでクッキーとフォームに入れる CSRF トークン
+const testCSRFToken = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+// testPassword は newUser で登録するユーザーのパスワード
+const testPassword = "correct horse battery"
+
+// TestMain はテスト中のログ（アクセスログなど）を出力しないようにしてからテストを実行する
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testMailer は送信したメールを記録する Mailer
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *testMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// testConfig はテスト用の設定を返す（設定ファイルは読まず、既定値に必須の項目だけを加える）
+func testConfig(t *testing.T) config.ConfigList {
+	t.Helper()
+	c, _, err := config.Load([]string{"--web.port", "8080", "--db.driver", "sqlite", "--mail.sender", "file", "--mail.dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// failingStore は fail に指定した操作だけを指定したエラーで失敗させる MemoryStore
+type failingStore struct {
+	*models.MemoryStore
+	fail map[string]error // 操作（メソッド名）ごとに返すエラー
+}
+
+func (s *failingStore) DeleteSessionsByUser(ctx context.Context, userID int) error {
+	if err := s.fail["DeleteSessionsByUser"]; err != nil {
+		return err
+	}
+	return s.MemoryStore.DeleteSessionsByUser(ctx, userID)
+}
+
+func (s *failingStore) UpdatePassword(ctx context.Context, id int, hashed string) error {
+	if err := s.fail["UpdatePassword"]; err != nil {
+		return err
+	}
+	return s.MemoryStore.UpdatePassword(ctx, id, hashed)
+}
+
+func (s *failingStore) ConsumeUserToken(ctx context.Context, purpose, token string) (int, error) {
+	if err := s.fail["ConsumeUserToken"]; err != nil {
+		return 0, err
+	}
+	return s.MemoryStore.ConsumeUserToken(ctx, purpose, token)
+}
+
+func (s *failingStore) GetTodo(ctx context.Context, userID, id int) (models.Todo, error) {
+	if err := s.fail["GetTodo"]; err != nil {
+		return models.Todo{}, err
+	}
+	return s.MemoryStore.GetTodo(ctx, userID, id)
+}
+
+// testServer は MemoryStore を注入した Server と、それを起動した httptest.Server の組
+type testServer struct {
+	*httptest.Server
+	store  *models.MemoryStore
+	mailer *testMailer
+}
+
+// newTestServer はストアに MemoryStore を使う Server を起動する
+func newTestServer(t *testing.T) *testServer {
+	return newFailingTestServer(t, nil)
+}
+
+// newFailingTestServer は fail に指定した操作が失敗するストア（failingStore）を使う Server を起動する
+func newFailingTestServer(t *testing.T, fail map[string]error) *testServer {
+	t.Helper()
+	return newConfiguredTestServer(t, testConfig(t), fail)
+}
+
+// newConfiguredTestServer は設定 c と failingStore を使う Server を起動する
+func newConfiguredTestServer(t *testing.T, c config.ConfigList, fail map[string]error) *testServer {
+	t.Helper()
+	store := &failingStore{MemoryStore: models.NewMemoryStore(), fail: fail}
+	mailer := &testMailer{}
+	s := NewServer(c, store, store, store, store, store, models.NewMemoryLoginLimiter(LoginPolicy(c)), mailer)
+	ts := &testServer{Server: httptest.NewServer(s.Handler()), store: store.MemoryStore, mailer: mailer}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// newUser はメールアドレス確認済みのユーザーを登録し、ログイン中のセッションを返す
+func (ts *testServer) newUser(t *testing.T, email string) (models.User, models.Session) {
+	t.Helper()
+	ctx := context.Background()
+	hashed, err := models.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.User{Name: email, Email: email, PassWord: hashed}
+	if err := ts.store.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.MarkVerified(ctx, u.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := ts.store.CreateSession(ctx, &u, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, sess
+}
+
+// responseCookie はレスポンスが設定した name のクッキーを返す（設定していなければ nil）
+func responseCookie(res *http.Response, name string) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// newTodo は user の Todo を登録する
+func (ts *testServer) newTodo(t *testing.T, user models.User, content string) models.Todo {
+	t.Helper()
+	todo := models.Todo{UserID: user.ID, Content: content}
+	if err := ts.store.CreateTodo(context.Background(), &todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+// testRequest はテストで送るリクエスト
+type testRequest struct {
+	method  string
+	path    string
+	session *models.Session // ログイン中のセッション（nil なら未ログイン）
+	form    url.Values      // フォームの項目（csrf_token は含まれていなければ testCSRFToken を入れる）
+	json    string          // JSON の本文（form と同時には指定しない）
+	csrf    *string         // クッキーに入れる CSRF トークン（nil なら testCSRFToken）
+	header  map[string]string
+	cookies []*http.Cookie // 追加で送るクッキー（前のレスポンスのフラッシュメッセージなど）
+}
+
+// do はリクエストを送り、リダイレクトをたどらずにレスポンスと本文を返す
+func (ts *testServer) do(t *testing.T, tr testRequest) (*http.Response, string) {
+	t.Helper()
+	var body io.Reader
+	switch {
+	case tr.form != nil:
+		if !tr.form.Has(csrfFieldName) {
+			tr.form.Set(csrfFieldName, testCSRFToken)
+		}
+		body = strings.NewReader(tr.form.Encode())
+	case tr.json != "":
+		body = strings.NewReader(tr.json)
+	}
+	req, err := http.NewRequest(tr.method, ts.URL+tr.path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case tr.form != nil:
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case tr.json != "":
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range tr.header {
+		req.Header.Set(k, v)
+	}
+	csrfCookie := testCSRFToken
+	if tr.csrf != nil {
+		csrfCookie = *tr.csrf
+	}
+	if csrfCookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: csrfCookie})
+	}
+	if tr.session != nil {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tr.session.UUID})
+	}
+	for _, c := range tr.cookies {
+		req.AddCookie(c)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(b)
+}
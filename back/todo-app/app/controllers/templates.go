@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"todo-app/app/views"
+	"todo-app/config"
+)
+
+// テンプレートのキャッシュ
+// 画面は layout・ナビゲーションバー（*_navbar）・内容の3つのテンプレートの組み合わせで構成する
+// 起動時にすべての組み合わせをパースしておき、リクエストごとにはパースしない
+// 開発モード（[web] dev = true）ではリクエストごとにディスクから読み直し、編集をすぐに反映する
+
+// templateFuncs はテンプレートから呼び出せる関数
+// パース時はこの仮の関数を登録し、実行時にリクエストごとの関数に置き換える
+var templateFuncs = template.FuncMap{
+	"csrfField": func() template.HTML { return "" },
+	"csrfToken": func() string { return "" },
+	"flash":     func() *flash { return nil },
+}
+
+// templateCache はパース済みのテンプレートをファイルの組み合わせごとに保持する
+type templateCache struct {
+	fsys fs.FS
+	dev  bool
+
+	mu   sync.RWMutex
+	sets map[string]*template.Template
+}
+
+// newTemplateCache は fsys のテンプレートを扱う templateCache を生成する
+// dev が true の場合はキャッシュせず、毎回 fsys からパースする
+func newTemplateCache(fsys fs.FS, dev bool) *templateCache {
+	return &templateCache{fsys: fsys, dev: dev, sets: map[string]*template.Template{}}
+}
+
+// viewFS はテンプレートと静的ファイルの読み込み元を返す
+// 開発モードでは c の [web] static のディレクトリ、それ以外はバイナリに埋め込んだファイルを使う
+func viewFS(c config.ConfigList) (templates fs.FS, static fs.FS) {
+	if c.Dev {
+		return os.DirFS(filepath.Join(c.Static, "templates")), os.DirFS(c.Static)
+	}
+	return views.Templates(), views.Static()
+}
+
+// templateKey はファイルの組み合わせのキーを返す（指定の順序によらず同じキーになる）
+func templateKey(filenames []string) string {
+	names := append([]string(nil), filenames...)
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// parse はファイルの組み合わせをパースする
+func (c *templateCache) parse(filenames []string) (*template.Template, error) {
+	files := make([]string, len(filenames))
+	for i, file := range filenames {
+		files[i] = file + ".html"
+	}
+	return template.New(files[0]).Funcs(templateFuncs).ParseFS(c.fsys, files...)
+}
+
+// lookup はファイルの組み合わせに対応するテンプレートを返す
+// 起動時に読み込んでいない組み合わせは初回にパースしてキャッシュする
+func (c *templateCache) lookup(filenames ...string) (*template.Template, error) {
+	if c.dev {
+		return c.parse(filenames)
+	}
+	key := templateKey(filenames)
+	c.mu.RLock()
+	t, ok := c.sets[key]
+	c.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	t, err := c.parse(filenames)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.sets[key] = t
+	c.mu.Unlock()
+	return t, nil
+}
+
+// preload は layout とナビゲーションバー・内容のすべての組み合わせをパースしてキャッシュする
+// テンプレートの誤りを起動時に検出するため、サーバーの起動前に呼び出す
+func (c *templateCache) preload() error {
+	if c.dev {
+		return nil
+	}
+	files, err := fs.Glob(c.fsys, "*.html")
+	if err != nil {
+		return err
+	}
+	var navbars, contents []string
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		switch {
+		case name == "layout":
+		case strings.HasSuffix(name, "_navbar"):
+			navbars = append(navbars, name)
+		default:
+			contents = append(contents, name)
+		}
+	}
+	for _, navbar := range navbars {
+		for _, content := range contents {
+			if _, err := c.lookup("layout", navbar, content); err != nil {
+				return fmt.Errorf("templates: parsing %s with %s: %w", content, navbar, err)
+			}
+		}
+	}
+	slog.Info("templates: cached pages", "count", len(navbars)*len(contents))
+	return nil
+}
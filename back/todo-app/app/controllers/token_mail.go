@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"time"
+	"todo-app/app/mail"
+	"todo-app/app/models"
+)
+
+// tokenMail はワンタイムトークンのリンクを記載して送るメールの内容
+type tokenMail struct {
+	purpose string                                      // トークンの用途（models.TokenPurposeXxx）
+	ttl     time.Duration                               // リンクの有効期間
+	path    string                                      // リンク先のパス（?token=... を付ける）
+	subject string                                      // 件名
+	body    func(link string, ttl time.Duration) string // リンクと有効期間から本文を作る
+}
+
+// sendTokenMail はユーザーのトークンを発行し、リンクを記載したメールを送る
+// 失敗した場合はログに出力し、呼び出し元の処理は続ける
+func (s *Server) sendTokenMail(ctx context.Context, user models.User, m tokenMail) {
+	token, err := s.tokens.CreateUserToken(ctx, user.ID, m.purpose, time.Now().Add(m.ttl))
+	if err != nil {
+		slog.ErrorContext(ctx, "sendTokenMail: error creating token", "purpose", m.purpose, "error", err)
+		return
+	}
+	link := s.config.BaseURL + m.path + "?" + url.Values{"token": {token}}.Encode()
+	err = s.mailer.Send(mail.Message{To: user.Email, Subject: m.subject, Body: m.body(link, m.ttl)})
+	if err != nil {
+		slog.ErrorContext(ctx, "sendTokenMail: error sending mail", "purpose", m.purpose, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "sendTokenMail: mail sent", "purpose", m.purpose, "user_id", user.ID)
+}
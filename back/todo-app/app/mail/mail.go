@@ -0,0 +1,73 @@
+// Package mail はユーザーへのメール送信を提供する
+// 送信方法は Mailer インターフェースで差し替えられる
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"todo-app/config"
+	"todo-app/utils"
+)
+
+// Message は送信するメール
+type Message struct {
+	To      string // 宛先のメールアドレス
+	Subject string // 件名
+	Body    string // 本文（プレーンテキスト）
+}
+
+// Mailer はメールを送信する
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer はメールを送信する代わりにログに出力する Mailer の実装（開発用）
+// ログを読めるだけでアカウントを乗っ取れないよう、本文のリンクのトークンは伏せて出力する
+// （リンクを開いて確認する場合は FileMailer を使う）
+type LogMailer struct{}
+
+// Send はメールの内容をログに出力する
+func (LogMailer) Send(msg Message) error {
+	slog.Info("mail: message", "to", utils.MaskEmail(msg.To), "subject", msg.Subject, "body", utils.RedactURLTokens(msg.Body))
+	return nil
+}
+
+// FileMailer はメールを1通ずつディレクトリ内のファイルに書き出す Mailer の実装（ローカル確認用）
+type FileMailer struct {
+	Dir  string // 書き出し先のディレクトリ
+	From string // 差出人のメールアドレス
+}
+
+// Send はメールをヘッダー付きのテキストファイル（.eml）として書き出す
+func (m FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102-150405.000000000"), fileNameReplacer.Replace(msg.To))
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, msg.To, mime.QEncoding.Encode("UTF-8", msg.Subject), now.Format(time.RFC1123Z), msg.Body)
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return err
+	}
+	slog.Info("mail: wrote message", "to", utils.MaskEmail(msg.To), "path", path)
+	return nil
+}
+
+// fileNameReplacer はメールアドレスをファイル名に使える文字に置き換える
+var fileNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "@", "_at_")
+
+// NewMailer は設定 c の [mail] sender に従って Mailer を生成する
+// file の場合は [mail] dir にファイルを書き出し、log（既定）の場合はログに出力する
+func NewMailer(c config.ConfigList) Mailer {
+	if c.MailSender == "file" {
+		return FileMailer{Dir: c.MailDir, From: c.MailFrom}
+	}
+	return LogMailer{}
+}
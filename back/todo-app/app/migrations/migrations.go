@@ -0,0 +1,289 @@
+// Package migrations はバージョン管理されたスキーママイグレーションを提供する
+// SQLファイルはダイアレクトごとのディレクトリ（postgres/, sqlite/）に置いてバイナリに埋め込み、
+// 適用状況は schema_migrations テーブルで管理する
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"todo-app/app/models"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// ErrDirty は途中で失敗したマイグレーションが残っている場合のエラー
+var ErrDirty = errors.New("migrations: schema is dirty")
+
+// ErrPending は未適用のマイグレーションがある場合のエラー
+var ErrPending = errors.New("migrations: schema has pending migrations")
+
+// fileNamePattern はマイグレーションファイル名（例: 0001_create_users.up.sql）にマッチする
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration は1つのバージョンに対応する up/down のSQLを保持する
+type Migration struct {
+	Version int    // バージョン番号（ファイル名の先頭の数字）
+	Name    string // マイグレーション名
+	Up      string // 適用時に実行するSQL
+	Down    string // 取り消し時に実行するSQL
+}
+
+// Status はマイグレーションごとの適用状況を表す
+type Status struct {
+	Migration
+	Applied   bool      // 適用済みか
+	Dirty     bool      // 適用・取り消しの途中で失敗したか
+	AppliedAt time.Time // 適用日時
+}
+
+// Migrator はマイグレーションの適用・取り消し・状況確認を行う
+type Migrator struct {
+	db         *sql.DB
+	dialect    models.Dialect
+	migrations []Migration
+}
+
+// New はダイアレクトに対応する埋め込みSQLファイルを読み込み、Migrator を生成する
+func New(db *sql.DB, dialect models.Dialect) (*Migrator, error) {
+	migrations, err := load(files, dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// load はディレクトリ内のSQLファイルをバージョン順に並べた Migration のスライスにする
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := fileNamePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrations: invalid file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrations: version %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d must have both up and down files", mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureTable は適用状況を記録する schema_migrations テーブルを作成する
+func (m *Migrator) ensureTable() error {
+	cmd := `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			dirty BOOLEAN NOT NULL,
+			applied_at TIMESTAMP NOT NULL)`
+	_, err := m.db.Exec(cmd)
+	return err
+}
+
+// applied は schema_migrations に記録されたバージョンごとの状況を返す
+func (m *Migrator) applied() (map[int]Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`select version, dirty, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]Status{}
+	for rows.Next() {
+		var s Status
+		if err := rows.Scan(&s.Version, &s.Dirty, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// Status は全マイグレーションの適用状況をバージョン順に返す
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := applied[mig.Version]
+		s.Migration = mig
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Check はスキーマが最新かを確認する
+// dirty なマイグレーションがあれば ErrDirty、未適用があれば ErrPending を返す
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.Dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, s.Version)
+		}
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			return fmt.Errorf("%w: version %d", ErrPending, s.Version)
+		}
+	}
+	return nil
+}
+
+// Up は未適用のマイグレーションをバージョン順にすべて適用し、適用した件数を返す
+func (m *Migrator) Up() (count int, err error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkClean(statuses); err != nil {
+		return 0, err
+	}
+
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+		if err := m.run(s.Migration, true); err != nil {
+			return count, err
+		}
+		slog.Info("migrations: applied", "version", s.Version, "name", s.Name)
+		count++
+	}
+	return count, nil
+}
+
+// Down は適用済みのマイグレーションを新しい順に n 件取り消し、取り消した件数を返す
+func (m *Migrator) Down(n int) (count int, err error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("migrations: down count must be positive, got %d", n)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkClean(statuses); err != nil {
+		return 0, err
+	}
+
+	for i := len(statuses) - 1; i >= 0 && count < n; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+		if err := m.run(s.Migration, false); err != nil {
+			return count, err
+		}
+		slog.Info("migrations: reverted", "version", s.Version, "name", s.Name)
+		count++
+	}
+	return count, nil
+}
+
+// checkClean は dirty なマイグレーションが残っていないことを確認する
+func checkClean(statuses []Status) error {
+	for _, s := range statuses {
+		if s.Dirty {
+			return fmt.Errorf("%w: version %d failed previously; fix the schema by hand and remove the row from schema_migrations", ErrDirty, s.Version)
+		}
+	}
+	return nil
+}
+
+// run は1件のマイグレーションを適用（up=true）または取り消し（up=false）する
+// 実行前に dirty として記録し、SQLと記録の更新を同じトランザクションで行う
+// 途中でプロセスが停止した場合は dirty のまま残り、サーバーの起動を止める
+func (m *Migrator) run(mig Migration, up bool) error {
+	if up {
+		_, err := m.db.Exec(m.dialect.Rebind(`insert into schema_migrations (version, dirty, applied_at) values ($1, $2, $3)`),
+			mig.Version, true, time.Now())
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := m.db.Exec(m.dialect.Rebind(`update schema_migrations set dirty = $1 where version = $2`), true, mig.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	body, record := mig.Down, `delete from schema_migrations where version = $1`
+	if up {
+		body, record = mig.Up, `update schema_migrations set dirty = false where version = $1`
+	}
+	if _, err = tx.Exec(body); err == nil {
+		_, err = tx.Exec(m.dialect.Rebind(record), mig.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		// トランザクションが巻き戻されたため、dirty の記録も元に戻す
+		m.restore(mig.Version, up)
+		return fmt.Errorf("migrations: %04d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return tx.Commit()
+}
+
+// restore は失敗したマイグレーションの dirty 記録を実行前の状態に戻す
+func (m *Migrator) restore(version int, up bool) {
+	var err error
+	if up {
+		_, err = m.db.Exec(m.dialect.Rebind(`delete from schema_migrations where version = $1`), version)
+	} else {
+		_, err = m.db.Exec(m.dialect.Rebind(`update schema_migrations set dirty = false where version = $1`), version)
+	}
+	if err != nil {
+		slog.Error("migrations: version is left dirty", "version", version, "error", err)
+	}
+}
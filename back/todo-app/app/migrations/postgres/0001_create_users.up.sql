@@ -0,0 +1,10 @@
+-- ユーザーテーブル
+-- 既存の環境（docker の初期化スクリプトで作成済み）でも適用できるよう IF NOT EXISTS を付ける
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    uuid UUID NOT NULL UNIQUE,
+    name VARCHAR(255),
+    email VARCHAR(255),
+    password VARCHAR(255),
+    created_at TIMESTAMP
+);
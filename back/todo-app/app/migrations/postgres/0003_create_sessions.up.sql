@@ -0,0 +1,15 @@
+-- セッションテーブル
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    uuid UUID NOT NULL UNIQUE,
+    email VARCHAR(255),
+    user_id INTEGER,
+    created_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+-- 有効期限カラムが追加される前に作成されたテーブル向け
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+-- 期限切れセッションの削除用インデックス
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
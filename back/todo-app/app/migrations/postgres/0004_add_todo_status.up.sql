@@ -0,0 +1,8 @@
+-- Todoの完了状態・期限・優先度
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS done BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at TIMESTAMP;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+
+-- 期限切れ一覧の取得用インデックス
+CREATE INDEX IF NOT EXISTS todos_user_id_due_at_idx ON todos (user_id, due_at);
@@ -0,0 +1,24 @@
+-- 以前のバージョンはローカル時刻として読み込むため、UTC の日時をセッションの TimeZone の時刻に戻す
+-- 適用時と同じく、サーバーのタイムゾーンを PGTZ に指定して取り消すこと
+
+UPDATE users SET
+    created_at = created_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    verified_at = verified_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone');
+
+UPDATE todos SET
+    created_at = created_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    completed_at = completed_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    due_at = due_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone');
+
+UPDATE sessions SET
+    created_at = created_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    expires_at = expires_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone');
+
+UPDATE login_attempts SET
+    last_failed_at = last_failed_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    locked_until = locked_until AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone');
+
+UPDATE user_tokens SET
+    created_at = created_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    expires_at = expires_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone'),
+    used_at = used_at AT TIME ZONE 'UTC' AT TIME ZONE current_setting('TimeZone');
@@ -0,0 +1,28 @@
+-- 日時を UTC にそろえる
+-- 以前のバージョンは TIMESTAMP（タイムゾーンなし）のカラムにサーバーのローカル時刻を保存していたため、
+-- UTC として読み込むとローカル時刻のオフセットの分だけずれる
+-- 変換元のタイムゾーンはセッションの TimeZone（既定はデータベースの設定）を使う
+-- サーバーをデータベースと異なるタイムゾーンで動かしていた場合は、PGTZ にサーバーのタイムゾーンを指定して適用すること
+-- （例: PGTZ=Asia/Tokyo go run main.go migrate up）
+
+UPDATE users SET
+    created_at = created_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    verified_at = verified_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC';
+
+UPDATE todos SET
+    created_at = created_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    completed_at = completed_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    due_at = due_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC';
+
+UPDATE sessions SET
+    created_at = created_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    expires_at = expires_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC';
+
+UPDATE login_attempts SET
+    last_failed_at = last_failed_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    locked_until = locked_until AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC';
+
+UPDATE user_tokens SET
+    created_at = created_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    expires_at = expires_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC',
+    used_at = used_at AT TIME ZONE current_setting('TimeZone') AT TIME ZONE 'UTC';
@@ -0,0 +1,9 @@
+-- ユーザーテーブル
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL UNIQUE,
+    name TEXT,
+    email TEXT,
+    password TEXT,
+    created_at TIMESTAMP
+);
@@ -0,0 +1,10 @@
+-- Todoテーブル
+CREATE TABLE IF NOT EXISTS todos (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    content TEXT,
+    user_id INTEGER,
+    created_at TIMESTAMP
+);
+
+-- ユーザーごとの一覧取得用インデックス
+CREATE INDEX IF NOT EXISTS todos_user_id_idx ON todos (user_id);
@@ -0,0 +1,12 @@
+-- セッションテーブル
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL UNIQUE,
+    email TEXT,
+    user_id INTEGER,
+    created_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+-- 期限切れセッションの削除用インデックス
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
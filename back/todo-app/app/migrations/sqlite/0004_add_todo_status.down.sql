@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS todos_user_id_due_at_idx;
+ALTER TABLE todos DROP COLUMN priority;
+ALTER TABLE todos DROP COLUMN due_at;
+ALTER TABLE todos DROP COLUMN completed_at;
+ALTER TABLE todos DROP COLUMN done;
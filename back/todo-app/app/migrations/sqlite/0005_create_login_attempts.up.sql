@@ -0,0 +1,8 @@
+-- ログイン失敗の記録（メールアドレス・接続元IPごと）
+-- key は "email:<メールアドレス>" または "ip:<IPアドレス>"
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
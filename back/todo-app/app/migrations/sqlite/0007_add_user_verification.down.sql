@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_email_lower_idx;
+ALTER TABLE users DROP COLUMN verified_at;
@@ -0,0 +1,9 @@
+-- メールアドレスの確認日時（未確認の場合は NULL）
+ALTER TABLE users ADD COLUMN verified_at TIMESTAMP;
+
+-- この機能より前に登録されたユーザーは確認済みとして扱う
+UPDATE users SET verified_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE verified_at IS NULL;
+
+-- メールアドレスは大文字小文字を区別せず一意にする
+-- 既に重複している場合は、このマイグレーションの前に手動で解消すること
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
@@ -0,0 +1,2 @@
+-- 書式のみの変更で、UTC の書式のままでも以前のバージョンで読み込めるため、取り消しでは何もしない
+SELECT 1;
@@ -0,0 +1,21 @@
+-- 日時を UTC の固定長の書式（2006-01-02 15:04:05.000000000+00:00）にそろえる
+-- SQLite は日時を文字列として比較するため、以前の書式（書き込んだ時点のオフセット付き・小数部の桁数が可変）では
+-- 期限切れの判定・期限での絞り込み・並び替えが正しく行えない
+-- strftime はオフセットを UTC に変換する（ミリ秒より細かい値は切り捨てる）。解釈できない値はそのまま残す
+
+UPDATE users SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;
+UPDATE users SET verified_at = strftime('%Y-%m-%d %H:%M:%f', verified_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', verified_at) IS NOT NULL;
+
+UPDATE todos SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;
+UPDATE todos SET completed_at = strftime('%Y-%m-%d %H:%M:%f', completed_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', completed_at) IS NOT NULL;
+UPDATE todos SET due_at = strftime('%Y-%m-%d %H:%M:%f', due_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', due_at) IS NOT NULL;
+
+UPDATE sessions SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;
+UPDATE sessions SET expires_at = strftime('%Y-%m-%d %H:%M:%f', expires_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', expires_at) IS NOT NULL;
+
+UPDATE login_attempts SET last_failed_at = strftime('%Y-%m-%d %H:%M:%f', last_failed_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', last_failed_at) IS NOT NULL;
+UPDATE login_attempts SET locked_until = strftime('%Y-%m-%d %H:%M:%f', locked_until) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', locked_until) IS NOT NULL;
+
+UPDATE user_tokens SET created_at = strftime('%Y-%m-%d %H:%M:%f', created_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', created_at) IS NOT NULL;
+UPDATE user_tokens SET expires_at = strftime('%Y-%m-%d %H:%M:%f', expires_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', expires_at) IS NOT NULL;
+UPDATE user_tokens SET used_at = strftime('%Y-%m-%d %H:%M:%f', used_at) || '000000+00:00' WHERE strftime('%Y-%m-%d %H:%M:%f', used_at) IS NOT NULL;
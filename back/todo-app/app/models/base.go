@@ -2,113 +2,246 @@
 package models
 
 import (
-	"crypto/sha1"
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 	"todo-app/config"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq" // PostgreSQL ドライバーをインポート
+	_ "github.com/lib/pq"           // PostgreSQL ドライバーをインポート
+	_ "github.com/mattn/go-sqlite3" // SQLite ドライバーをインポート
 )
 
-// Db はアプリケーション全体で使用されるデータベース接続
-var Db *sql.DB
-
-// err はデータベース関連のエラーを保持する変数
-var err error
-
-// テーブル名の定数
+// 起動時の接続の再試行の間隔（失敗ごとに2倍にし、connectRetryMaxDelay で頭打ちにする）
 const (
-	tableNameUser    = "users"
-	tableNameTodo    = "todos"
-	tableNameSession = "sessions"
+	connectRetryBaseDelay = 500 * time.Millisecond
+	connectRetryMaxDelay  = 10 * time.Second
 )
 
-// ここでデータベース接続の初期化とテーブルのセットアップを行います。
-func init() {
+// OpenDB は設定 c の [db] セクションに従ってデータベースに接続し、接続を確認した *sql.DB とダイアレクトを返す
+// driver が postgres の場合は PostgreSQL、sqlite の場合は path のファイルを使う
+// コネクションプールは [db] の max_open_conns などで設定する
+// データベースの準備ができていない場合は connect_timeout まで間隔を延ばしながら接続を再試行する（ctx が取り消されると中断する）
+// テーブルの作成・変更は migrations パッケージのマイグレーションで行う
+func OpenDB(ctx context.Context, c config.ConfigList) (*sql.DB, Dialect, error) {
+	dialect, err := DialectFor(c.SQLDriver)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// データベース接続文字列を生成します。
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		config.Config.DbHost,     // データベースホスト名
-		config.Config.DbPort,     // データベースポート番号
-		config.Config.DbUser,     // データベースユーザー名
-		config.Config.DbPassword, // データベースパスワード
-		config.Config.DbName)     // データベース名
+	var connStr string
+	switch dialect {
+	case Postgres:
+		connStr = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			c.DbHost,     // データベースホスト名
+			c.DbPort,     // データベースポート番号
+			c.DbUser,     // データベースユーザー名
+			c.DbPassword, // データベースパスワード
+			c.DbName)     // データベース名
+	case SQLite:
+		// 外部キー制約を有効にし、書き込みの競合はWALモードとビジータイムアウトで待つ
+		connStr = fmt.Sprintf("file:%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000",
+			c.DbPath) // データベースファイルのパス
+	}
 
 	// データベースに接続を試みます。
 	// sql.Open はすぐに接続を確立するのではなく、DB オブジェクトを準備します。
-	Db, err = sql.Open(config.Config.SQLDriver, connStr) // SQLDriverもconfigから取得
+	db, err := sql.Open(dialect.Driver(), connStr)
 	if err != nil {
-		log.Fatalln("Failed to open database connection:", err) // 接続失敗時は致命的なエラーとして終了
+		return nil, nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
 
+	// コネクションプールを設定します。
+	db.SetMaxOpenConns(c.DbMaxOpenConns)
+	db.SetMaxIdleConns(c.DbMaxIdleConns)
+	db.SetConnMaxLifetime(c.DbConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.DbConnMaxIdleTime)
+
 	// データベースへの接続を確認します。
-	err = Db.Ping()
+	err = waitForDB(ctx, db, dialect, c.DbConnectTimeout)
 	if err != nil {
-		log.Fatalln("Failed to connect to database:", err) // 接続確認失敗時も致命的なエラーとして終了
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	log.Println("Database connection established successfully!") // 接続成功をログ出力
-
-	/*
-		// ユーザーテーブルを作成するSQLコマンド
-		cmdU := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
-				id SERIAL PRIMARY KEY,
-				uuid UUID NOT NULL UNIQUE,
-				name VARCHAR(255),
-				email VARCHAR(255),
-				password VARCHAR(255),
-				created_at TIMESTAMP
-				);`, tableNameUser)
-
-		_, err := Db.Exec(cmdU) // テーブル作成クエリを実行
-		if err != nil {
-			log.Printf("Error creating %s table: %v", tableNameUser, err) // エラーをログ出力
-		}
+	slog.Info("Database connection established successfully", "dialect", dialect.Name(), // 接続成功をログ出力
+		"max_open_conns", c.DbMaxOpenConns, "max_idle_conns", c.DbMaxIdleConns)
+
+	return db, dialect, nil
+}
 
-		log.Printf("%s table creation attempted.", tableNameUser) // 実行試行のログを追加
-	*/
-	/*
-		// Todoテーブルを作成するSQLコマンド
-		cmdT := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s(
-				id SERIAL PRIMARY KEY, // AUTOINCREMENT から SERIAL に変更
-				content TEXT,
-				user_id INTEGER,
-				created_at TIMESTAMP
-				);`, tableNameTodo)
-
-		_, err = Db.Exec(cmdT) // テーブル作成クエリを実行
-		if err != nil {
-			log.Printf("Error creating %s table: %v", tableNameTodo, err) // エラーをログ出力
+// waitForDB はデータベースに接続できるまで Ping を再試行する
+// docker-compose などでアプリケーションがデータベースより先に起動した場合に備える
+// 接続できない・起動中といった一時的なエラーのみ再試行し、認証の失敗などはすぐに返す
+func waitForDB(ctx context.Context, db *sql.DB, dialect Dialect, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := connectRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if !dialect.IsUnavailable(err) && !isConnectionError(err) {
+			return err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("database not ready after %d attempt(s): %w", attempt, err)
+		}
+		slog.Warn("database not ready; retrying", "attempt", attempt, "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
+		delay = min(delay*2, connectRetryMaxDelay)
+	}
+}
+
+// SQLStore は database/sql を使った UserStore / TodoStore / SessionStore の実装
+// ダイアレクトを切り替えることで PostgreSQL と SQLite の両方で動作する
+// クエリは呼び出し元のコンテキスト（リクエストの r.Context()）で実行し、
+// クライアントが切断した場合や queryTimeout を過ぎた場合は実行中のクエリを取り消す
+type SQLStore struct {
+	db           *sql.DB
+	dialect      Dialect
+	queryTimeout time.Duration
+}
+
+// NewSQLStore は接続済みの *sql.DB とダイアレクトから SQLStore を生成する
+// queryTimeout は1回の操作にかけられる時間の上限（0以下の場合は呼び出し元のコンテキストのみに従う）
+func NewSQLStore(db *sql.DB, dialect Dialect, queryTimeout time.Duration) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
 
-		log.Printf("%s table creation attempted.", tableNameTodo) // 実行試行のログを追加
-	*/
+// begin は操作のタイムアウトを設定したコンテキストを返す
+// SQLStore の各メソッドの先頭で ctx, done := s.begin(ctx, "メソッド名", &err); defer done() として呼び出す
+// done はエラーを StoreError に置き換え（wrapErr）、コンテキストを解放する
+func (s *SQLStore) begin(ctx context.Context, op string, errp *error) (context.Context, func()) {
+	var cancel context.CancelFunc
+	if s.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	return ctx, func() {
+		s.wrapErr(ctx, op, errp)
+		cancel()
+	}
+}
 
-	// セッションテーブルを作成するSQLコマンド
-	cmdS := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s(
-			id SERIAL PRIMARY KEY,
-			uuid UUID NOT NULL UNIQUE,
-			email VARCHAR(255),
-			user_id INTEGER,
-			created_at TIMESTAMP)`, tableNameSession)
+// exec はプレースホルダーと日時の引数をダイアレクトに合わせて変換し、クエリを実行する
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.Rebind(query), s.bindTimes(args)...)
+}
+
+// query はプレースホルダーと日時の引数をダイアレクトに合わせて変換し、複数行を取得する
+func (s *SQLStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.Rebind(query), s.bindTimes(args)...)
+}
 
-	_, err := Db.Exec(cmdS) // テーブル作成クエリを実行
+// queryRow はプレースホルダーと日時の引数をダイアレクトに合わせて変換し、1行を取得する
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.Rebind(query), s.bindTimes(args)...)
+}
+
+// sqlTx はトランザクションの中で SQLStore の exec・queryRow と同じ変換をしてクエリを実行する
+type sqlTx struct {
+	store *SQLStore
+	tx    *sql.Tx
+}
+
+// exec はトランザクションの中でクエリを実行する
+func (t sqlTx) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, t.store.dialect.Rebind(query), t.store.bindTimes(args)...)
+}
+
+// queryRow はトランザクションの中で1行を取得する
+func (t sqlTx) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(ctx, t.store.dialect.Rebind(query), t.store.bindTimes(args)...)
+}
+
+// withTx は fn をトランザクションの中で実行し、エラーがなければコミットする
+// fn がエラーを返した場合はロールバックしてそのエラーを返す
+func (s *SQLStore) withTx(ctx context.Context, fn func(tx sqlTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error creating %s table: %v", tableNameSession, err) // エラーをログ出力
+		return err
+	}
+	if err := fn(sqlTx{store: s, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
 	}
+	return tx.Commit()
+}
 
-	log.Printf("%s table creation attempted.", tableNameSession) // 実行試行のログを追加
+// bindTimes は引数の日時（time.Time・*time.Time・sql.NullTime）を Dialect.BindTime で変換した引数を返す
+// 呼び出し元のタイムゾーンによらず、日時を UTC で保存・比較する
+func (s *SQLStore) bindTimes(args []interface{}) []interface{} {
+	bound := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			arg = s.dialect.BindTime(v)
+		case *time.Time:
+			if v != nil {
+				arg = s.dialect.BindTime(*v)
+			}
+		case sql.NullTime:
+			if v.Valid {
+				arg = s.dialect.BindTime(v.Time)
+			}
+		}
+		bound[i] = arg
+	}
+	return bound
+}
+
+// inLocal は読み込んだ日時（*time.Time・**time.Time）をローカル時刻にする
+// UTC で保存した日時を、MemoryStore や画面のフォームと同じくサーバーのローカル時刻で扱う
+func inLocal(times ...interface{}) {
+	for _, t := range times {
+		switch v := t.(type) {
+		case *time.Time:
+			if !v.IsZero() {
+				*v = v.In(time.Local)
+			}
+		case **time.Time:
+			if *v != nil {
+				local := (*v).In(time.Local)
+				*v = &local
+			}
+		}
+	}
+}
+
+// Ping はデータベースに接続できるかを確認する
+func (s *SQLStore) Ping(ctx context.Context) (err error) {
+	ctx, done := s.begin(ctx, "Ping", &err)
+	defer done()
+	return s.db.PingContext(ctx)
+}
+
+// PoolStats はデータベースの種類とコネクションプールの統計を返す
+func (s *SQLStore) PoolStats() PoolStats {
+	return PoolStats{Driver: s.dialect.Name(), DBStats: s.db.Stats()}
 }
 
 // createUUID は新しいUUIDを生成するヘルパー関数
-func createUUID() (uuidobj uuid.UUID) {
-	uuidobj, _ = uuid.NewUUID()
-	return uuidobj
+// セッションIDにも使うため、時刻やMACアドレスから推測できない乱数ベースの v4（crypto/rand）を使う
+func createUUID() uuid.UUID {
+	return uuid.New()
 }
 
-// Encrypt は SHA1 を使用して文字列をハッシュ化する関数
-func Encrypt(plaintext string) (cryptext string) {
-	cryptext = fmt.Sprintf("%x", sha1.Sum([]byte(plaintext)))
-	return cryptext
+// requireAffected は更新・削除の対象行が存在しなかった場合に sql.ErrNoRows を返す
+func requireAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect はデータベースごとのSQLの違いを吸収する
+// クエリは PostgreSQL 形式のプレースホルダー（$1, $2, ...）で記述し、Rebind で各DB向けに変換する
+type Dialect interface {
+	// Name はダイアレクト名を返す（マイグレーションのディレクトリ名にも使う）
+	Name() string
+	// Driver は sql.Open に渡すドライバー名を返す
+	Driver() string
+	// Rebind はクエリのプレースホルダーをこのDB向けに変換する
+	Rebind(query string) string
+	// IsUniqueViolation はエラーが一意制約違反によるものかを返す
+	IsUniqueViolation(err error) bool
+	// IsUnavailable はエラーが接続の失敗やロックの競合など、一時的な理由によるものかを返す
+	IsUnavailable(err error) bool
+	// BindTime は日時をクエリの引数として渡す値に変換する（日時はすべて UTC で保存・比較する）
+	BindTime(t time.Time) interface{}
+}
+
+// Postgres は PostgreSQL 用のダイアレクト
+var Postgres Dialect = postgresDialect{}
+
+// SQLite は SQLite 用のダイアレクト
+var SQLite Dialect = sqliteDialect{}
+
+// DialectFor は config の driver の値に対応するダイアレクトを返す
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "postgres":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", driver)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string   { return "postgres" }
+func (postgresDialect) Driver() string { return "postgres" }
+
+// Rebind は PostgreSQL ではクエリをそのまま返す
+func (postgresDialect) Rebind(query string) string { return query }
+
+// BindTime は UTC の日時を返す
+// TIMESTAMP（タイムゾーンなし）のカラムはオフセットを捨てて時刻だけを保存するため、UTC にそろえる
+func (postgresDialect) BindTime(t time.Time) interface{} { return t.UTC() }
+
+// IsUniqueViolation は SQLSTATE 23505（unique_violation）かを返す
+func (postgresDialect) IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsUnavailable は接続の例外（クラス 08）、リソース不足（クラス 53）、
+// サーバーの停止・起動中（57P01〜57P03）の SQLSTATE かを返す
+func (postgresDialect) IsUnavailable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code.Class() {
+	case "08", "53":
+		return true
+	}
+	switch pqErr.Code {
+	case "57P01", "57P02", "57P03":
+		return true
+	}
+	return false
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string   { return "sqlite" }
+func (sqliteDialect) Driver() string { return "sqlite3" }
+
+// postgresPlaceholder は $1 形式のプレースホルダーにマッチする
+var postgresPlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// sqliteTimeFormat は SQLite に保存する日時の書式
+// SQLite は日時を文字列として比較するため、UTC の固定長の書式にして文字列の順序と時刻の順序を一致させる
+// （ドライバーの既定の書式はオフセットと小数部の桁数が値ごとに異なり、正しく比較できない）
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// BindTime は UTC の日時を固定長の文字列にして返す（読み込み時はドライバーが time.Time に変換する）
+func (sqliteDialect) BindTime(t time.Time) interface{} { return t.UTC().Format(sqliteTimeFormat) }
+
+// Rebind は $1 形式のプレースホルダーを SQLite の番号付きプレースホルダー ?1 に変換する
+// 番号付きのため、同じ引数を複数回参照するクエリもそのまま動作する
+func (sqliteDialect) Rebind(query string) string {
+	return postgresPlaceholder.ReplaceAllString(query, "?$1")
+}
+
+// IsUniqueViolation は SQLITE_CONSTRAINT_UNIQUE かを返す
+func (sqliteDialect) IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// IsUnavailable はデータベースがロック中（SQLITE_BUSY / SQLITE_LOCKED）またはファイルを開けない場合かを返す
+func (sqliteDialect) IsUnavailable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrCantOpen:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// ストアのエラー
+// ストアの各メソッドは、失敗の種類を errors.Is で判定できるエラー（StoreError）を返す
+// コントローラーは種類に応じて 404 / 409 / 503 などのレスポンスを返す
+// クライアントの切断で取り消された操作は ErrCanceled、タイムアウトは ErrUnavailable になる
+
+var (
+	// ErrNotFound は取得・更新・削除の対象が存在しない場合のエラー（sql.ErrNoRows としても判定できる）
+	ErrNotFound = errors.New("not found")
+	// ErrConflict は一意制約違反など、他のデータと競合した場合のエラー
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable はデータベースに接続できない・混雑しているなど、時間をおけば成功する可能性がある場合のエラー
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrCanceled はクライアントの切断などで呼び出し元のコンテキストが取り消され、操作を中断した場合のエラー
+	ErrCanceled = errors.New("canceled")
+)
+
+// StoreError はストアの操作で発生したエラー
+type StoreError struct {
+	Op   string // 失敗した操作（メソッド名）
+	Kind error  // ErrNotFound / ErrConflict / ErrUnavailable / ErrCanceled（分類できない場合は nil）
+	Err  error  // 元のエラー
+}
+
+// Error は「操作名: 元のエラー」を返す
+func (e *StoreError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap は種類と元のエラーを返し、errors.Is でどちらとも比較できるようにする
+func (e *StoreError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// errNotFound は MemoryStore が対象を見つけられなかった場合に返すエラー
+var errNotFound error = &StoreError{Op: "MemoryStore", Kind: ErrNotFound, Err: sql.ErrNoRows}
+
+// wrapErr は *errp を操作名と種類を付けた StoreError に置き換える（begin が返す done から呼び出される）
+// ctx は操作に使ったコンテキストで、取り消し・タイムアウトで失敗した場合はその旨をログに出力する
+func (s *SQLStore) wrapErr(ctx context.Context, op string, errp *error) {
+	if *errp == nil {
+		return
+	}
+	kind := s.classify(*errp)
+	switch ctxErr := ctx.Err(); {
+	case errors.Is(ctxErr, context.Canceled):
+		// ドライバーによっては取り消しを独自のエラーで返すため、コンテキストの状態で判定する
+		kind = ErrCanceled
+		slog.InfoContext(ctx, "store: query canceled by caller", "op", op, "error", *errp)
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		kind = ErrUnavailable
+		slog.WarnContext(ctx, "store: query timed out", "op", op, "timeout", s.queryTimeout, "error", *errp)
+	}
+	*errp = &StoreError{Op: op, Kind: kind, Err: *errp}
+}
+
+// classify はエラーの種類を判定する
+// ErrDuplicateEmail のように既に種類を持つエラーは nil を返し、元のエラーの種類をそのまま使う
+func (s *SQLStore) classify(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrConflict), errors.Is(err, ErrUnavailable), errors.Is(err, ErrCanceled):
+		return nil
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case s.dialect.IsUniqueViolation(err):
+		return ErrConflict
+	case s.dialect.IsUnavailable(err) || isConnectionError(err):
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// isConnectionError はデータベースとの接続に関するエラー（ドライバーによらないもの）かを返す
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ログイン試行の制限
+// 認証の失敗をメールアドレスごと・接続元IPごとに記録し、失敗が続くと次の試行までの待ち時間を
+// 段階的に延ばし、上限を超えると一定時間ロックする
+
+// ログイン試行を数える単位
+const (
+	LoginKeyEmail = "email" // メールアドレスごと
+	LoginKeyIP    = "ip"    // 接続元IPごと
+)
+
+// LoginKey はログイン試行を数える対象（メールアドレスまたは接続元IP）
+type LoginKey struct {
+	Kind  string // LoginKeyEmail または LoginKeyIP
+	Value string // メールアドレスまたはIPアドレス
+}
+
+// EmailLoginKey はメールアドレスの LoginKey を返す（大文字小文字は区別しない）
+func EmailLoginKey(email string) LoginKey {
+	return LoginKey{Kind: LoginKeyEmail, Value: strings.ToLower(strings.TrimSpace(email))}
+}
+
+// IPLoginKey は接続元IPの LoginKey を返す
+func IPLoginKey(ip string) LoginKey {
+	return LoginKey{Kind: LoginKeyIP, Value: ip}
+}
+
+// String は記録に使うキー（"email:..." / "ip:..."）を返す
+func (k LoginKey) String() string {
+	return k.Kind + ":" + k.Value
+}
+
+// LoginStatus はキーごとのログイン失敗の状況
+type LoginStatus struct {
+	Failures    int       // 連続した失敗回数
+	LastFailure time.Time // 最後に失敗した日時
+	LockedUntil time.Time // ロックの解除日時（ロックされていない場合はゼロ値）
+}
+
+// Locked は now の時点でロック中かを返す
+func (st LoginStatus) Locked(now time.Time) bool {
+	return now.Before(st.LockedUntil)
+}
+
+// LoginPolicy はログイン試行の制限の設定
+type LoginPolicy struct {
+	MaxEmailFailures int           // メールアドレスごとにロックするまでの失敗回数
+	MaxIPFailures    int           // 接続元IPごとにロックするまでの失敗回数
+	Window           time.Duration // 失敗回数を数える期間（この期間失敗がなければリセットされる）
+	Lockout          time.Duration // ロックする時間
+	BaseDelay        time.Duration // 1回目の失敗後に次の試行まで待つ時間（失敗ごとに2倍になる）
+	MaxDelay         time.Duration // 待ち時間の上限
+}
+
+// MaxFailures はキーの種類に応じたロックまでの失敗回数を返す
+func (p LoginPolicy) MaxFailures(kind string) int {
+	if kind == LoginKeyIP {
+		return p.MaxIPFailures
+	}
+	return p.MaxEmailFailures
+}
+
+// Wait は次にログインを試行できるまでの待ち時間を返す（すぐに試行できる場合は0）
+// ロック中は解除までの時間、そうでなければ失敗回数に応じて段階的に延びる待ち時間の残りを返す
+func (p LoginPolicy) Wait(st LoginStatus, now time.Time) time.Duration {
+	if st.Locked(now) {
+		return st.LockedUntil.Sub(now)
+	}
+	if st.Failures == 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(st.Failures-1)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if wait := st.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// LoginLimiter はログイン失敗を記録し、試行を制限する
+type LoginLimiter interface {
+	// Status はキーの現在の状況を返す
+	Status(ctx context.Context, key LoginKey, now time.Time) (LoginStatus, error)
+	// Fail は失敗を記録し、記録後の状況を返す（上限に達した場合はロックする）
+	Fail(ctx context.Context, key LoginKey, now time.Time) (LoginStatus, error)
+	// Reserve は待ち時間中でなければ試行を失敗として先に記録し、記録後の状況を返す（上限に達した場合はロックする）
+	// 待ち時間中の場合は記録せず、残りの待ち時間を返す
+	// 確認と記録を不可分に行うため、同時に送られた試行も1回ずつ数えられ、制限を超えて認証されない
+	Reserve(ctx context.Context, key LoginKey, now time.Time) (LoginStatus, time.Duration, error)
+	// Release は Reserve で記録した1回分の失敗を取り消す（試行が失敗ではなかった場合）
+	// 取り消して上限を下回った場合はロックも解除する
+	Release(ctx context.Context, key LoginKey, now time.Time) error
+	// Reset はキーの失敗記録とロックを消す（ログイン成功時やロック解除時）
+	Reset(ctx context.Context, key LoginKey) error
+}
+
+// MemoryLoginLimiter はトークンバケットでログイン試行を制限する LoginLimiter の実装
+// キーごとに上限回数分のトークンを持ち、失敗ごとに1つ消費し、Window で満杯まで回復する
+// トークンがなくなると Lockout の間ロックし、解除時に満杯に戻す
+// 記録はプロセス内のみで、複数のサーバーでは共有されない
+type MemoryLoginLimiter struct {
+	mu      sync.Mutex
+	policy  LoginPolicy
+	buckets map[string]*loginBucket
+}
+
+// loginBucket はキーごとのトークンバケット
+type loginBucket struct {
+	tokens      float64   // 残りのトークン数
+	updated     time.Time // トークン数を最後に計算した日時
+	lastFailure time.Time // 最後に失敗した日時
+	lockedUntil time.Time // ロックの解除日時
+}
+
+// NewMemoryLoginLimiter は MemoryLoginLimiter を生成する
+func NewMemoryLoginLimiter(policy LoginPolicy) *MemoryLoginLimiter {
+	return &MemoryLoginLimiter{policy: policy, buckets: map[string]*loginBucket{}}
+}
+
+// refill は経過時間に応じてトークンを回復させる
+func (l *MemoryLoginLimiter) refill(b *loginBucket, capacity float64, now time.Time) {
+	if !b.lockedUntil.IsZero() {
+		// ロック中は回復させず、解除されたら満杯に戻す
+		if !now.Before(b.lockedUntil) {
+			b.tokens, b.lockedUntil = capacity, time.Time{}
+		}
+	} else if l.policy.Window > 0 && now.After(b.updated) {
+		b.tokens += capacity * float64(now.Sub(b.updated)) / float64(l.policy.Window)
+	}
+	b.tokens = math.Min(b.tokens, capacity)
+	b.updated = now
+}
+
+// status はバケットの状態を LoginStatus に変換する
+func (b *loginBucket) status(capacity float64) LoginStatus {
+	return LoginStatus{
+		Failures:    int(math.Ceil(capacity - b.tokens)),
+		LastFailure: b.lastFailure,
+		LockedUntil: b.lockedUntil,
+	}
+}
+
+// Status はキーの現在の状況を返す
+func (l *MemoryLoginLimiter) Status(_ context.Context, key LoginKey, now time.Time) (LoginStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key.String()]
+	if !ok {
+		return LoginStatus{}, nil
+	}
+	capacity := float64(l.policy.MaxFailures(key.Kind))
+	l.refill(b, capacity, now)
+	if b.tokens >= capacity {
+		// 満杯まで回復したバケットは破棄する
+		delete(l.buckets, key.String())
+		return LoginStatus{}, nil
+	}
+	return b.status(capacity), nil
+}
+
+// bucket はキーのバケットを回復させてから返す（ない場合は満杯のバケットを作る）
+// 呼び出し元で l.mu をロックしておく
+func (l *MemoryLoginLimiter) bucket(key LoginKey, now time.Time) (*loginBucket, float64) {
+	capacity := float64(l.policy.MaxFailures(key.Kind))
+	b, ok := l.buckets[key.String()]
+	if !ok {
+		b = &loginBucket{tokens: capacity, updated: now}
+		l.buckets[key.String()] = b
+	}
+	l.refill(b, capacity, now)
+	return b, capacity
+}
+
+// fail はトークンを1つ消費し、なくなった場合はロックする
+func (l *MemoryLoginLimiter) fail(b *loginBucket, capacity float64, now time.Time) LoginStatus {
+	b.tokens = math.Max(b.tokens-1, 0)
+	b.lastFailure = now
+	if b.tokens < 1 && b.lockedUntil.IsZero() {
+		b.lockedUntil = now.Add(l.policy.Lockout)
+	}
+	return b.status(capacity)
+}
+
+// Fail は失敗を記録し、トークンがなくなった場合はロックする
+func (l *MemoryLoginLimiter) Fail(_ context.Context, key LoginKey, now time.Time) (LoginStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, capacity := l.bucket(key, now)
+	return l.fail(b, capacity, now), nil
+}
+
+// Reserve は待ち時間中でなければトークンを1つ消費し、待ち時間中の場合は残りの待ち時間を返す
+func (l *MemoryLoginLimiter) Reserve(_ context.Context, key LoginKey, now time.Time) (LoginStatus, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, capacity := l.bucket(key, now)
+	st := b.status(capacity)
+	if wait := l.policy.Wait(st, now); wait > 0 {
+		return st, wait, nil
+	}
+	return l.fail(b, capacity, now), 0, nil
+}
+
+// Release は Reserve で消費したトークンを1つ戻す
+func (l *MemoryLoginLimiter) Release(_ context.Context, key LoginKey, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key.String()]
+	if !ok {
+		return nil
+	}
+	capacity := float64(l.policy.MaxFailures(key.Kind))
+	l.refill(b, capacity, now)
+	b.tokens = math.Min(b.tokens+1, capacity)
+	if b.tokens >= 1 {
+		b.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+// Reset はキーの失敗記録とロックを消す
+func (l *MemoryLoginLimiter) Reset(_ context.Context, key LoginKey) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.buckets, key.String())
+	return nil
+}
+
+// SQLLoginLimiter はデータベースの login_attempts テーブルに失敗を記録する LoginLimiter の実装
+// 記録は複数のサーバーで共有され、再起動しても失われない
+// 失敗回数は Window の間に失敗がなければリセットされる
+type SQLLoginLimiter struct {
+	store  *SQLStore
+	policy LoginPolicy
+}
+
+// NewSQLLoginLimiter は SQLStore の接続を使う SQLLoginLimiter を生成する
+func NewSQLLoginLimiter(store *SQLStore, policy LoginPolicy) *SQLLoginLimiter {
+	return &SQLLoginLimiter{store: store, policy: policy}
+}
+
+// Status はキーの現在の状況を返す
+// 記録中の更新は待たずに読み込むため、試行の可否の判断には Reserve を使う
+func (l *SQLLoginLimiter) Status(ctx context.Context, key LoginKey, now time.Time) (st LoginStatus, err error) {
+	ctx, done := l.store.begin(ctx, "LoginLimiter.Status", &err)
+	defer done()
+	var lockedUntil sql.NullTime
+	cmd := `select failures, last_failed_at, locked_until from login_attempts where key = $1`
+	err = l.store.queryRow(ctx, cmd, key.String()).Scan(&st.Failures, &st.LastFailure, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginStatus{}, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	st.LockedUntil = lockedUntil.Time
+	inLocal(&st.LastFailure, &st.LockedUntil)
+	return l.expire(st, now), nil
+}
+
+// expire は期限の切れた記録を失敗なしとして扱う
+func (l *SQLLoginLimiter) expire(st LoginStatus, now time.Time) LoginStatus {
+	if st.Locked(now) {
+		return st
+	}
+	if !st.LockedUntil.IsZero() || now.Sub(st.LastFailure) > l.policy.Window {
+		// ロックが解除された、または Window の間失敗がなかった
+		return LoginStatus{}
+	}
+	return st
+}
+
+// update はキーの行をロックした状態で現在の状況を読み込み、fn が返した状況で更新する
+// fn が false を返した場合は更新しない
+// 先に行を挿入（既存の行は自身の値で更新）して書き込みのロックを取ってから読み込むため、
+// 同じキーへの同時の更新は1つずつ行われる（PostgreSQL は行ロック、SQLite はデータベースのロック）
+func (l *SQLLoginLimiter) update(ctx context.Context, key LoginKey, now time.Time, fn func(st LoginStatus) (LoginStatus, bool)) (st LoginStatus, err error) {
+	err = l.store.withTx(ctx, func(tx sqlTx) error {
+		lock := `insert into login_attempts (key, failures, last_failed_at) values ($1, 0, $2)
+		on conflict (key) do update set failures = login_attempts.failures`
+		if _, err := tx.exec(ctx, lock, key.String(), now); err != nil {
+			return err
+		}
+		var lockedUntil sql.NullTime
+		cmd := `select failures, last_failed_at, locked_until from login_attempts where key = $1`
+		if err := tx.queryRow(ctx, cmd, key.String()).Scan(&st.Failures, &st.LastFailure, &lockedUntil); err != nil {
+			return err
+		}
+		st.LockedUntil = lockedUntil.Time
+		inLocal(&st.LastFailure, &st.LockedUntil)
+
+		var ok bool
+		if st, ok = fn(l.expire(st, now)); !ok {
+			return nil
+		}
+		lockedUntil = sql.NullTime{Time: st.LockedUntil, Valid: !st.LockedUntil.IsZero()}
+		cmd = `update login_attempts set failures = $2, last_failed_at = $3, locked_until = $4 where key = $1`
+		_, err := tx.exec(ctx, cmd, key.String(), st.Failures, st.LastFailure, lockedUntil)
+		return err
+	})
+	return st, err
+}
+
+// fail は失敗を1回加えた状況を返し、失敗回数が上限に達した場合はロックする
+func (l *SQLLoginLimiter) fail(key LoginKey, st LoginStatus, now time.Time) LoginStatus {
+	st.Failures++
+	st.LastFailure = now
+	if !st.Locked(now) && st.Failures >= l.policy.MaxFailures(key.Kind) {
+		st.LockedUntil = now.Add(l.policy.Lockout)
+	}
+	return st
+}
+
+// Fail は失敗を記録し、失敗回数が上限に達した場合はロックする
+func (l *SQLLoginLimiter) Fail(ctx context.Context, key LoginKey, now time.Time) (st LoginStatus, err error) {
+	ctx, done := l.store.begin(ctx, "LoginLimiter.Fail", &err)
+	defer done()
+	return l.update(ctx, key, now, func(st LoginStatus) (LoginStatus, bool) {
+		return l.fail(key, st, now), true
+	})
+}
+
+// Reserve は待ち時間中でなければ失敗を記録し、待ち時間中の場合は残りの待ち時間を返す
+func (l *SQLLoginLimiter) Reserve(ctx context.Context, key LoginKey, now time.Time) (st LoginStatus, wait time.Duration, err error) {
+	ctx, done := l.store.begin(ctx, "LoginLimiter.Reserve", &err)
+	defer done()
+	st, err = l.update(ctx, key, now, func(st LoginStatus) (LoginStatus, bool) {
+		if wait = l.policy.Wait(st, now); wait > 0 {
+			return st, false
+		}
+		return l.fail(key, st, now), true
+	})
+	return st, wait, err
+}
+
+// Release は Reserve で記録した1回分の失敗を取り消す
+func (l *SQLLoginLimiter) Release(ctx context.Context, key LoginKey, now time.Time) (err error) {
+	ctx, done := l.store.begin(ctx, "LoginLimiter.Release", &err)
+	defer done()
+	_, err = l.update(ctx, key, now, func(st LoginStatus) (LoginStatus, bool) {
+		if st.Failures == 0 {
+			return st, false
+		}
+		st.Failures--
+		if st.Failures < l.policy.MaxFailures(key.Kind) {
+			st.LockedUntil = time.Time{}
+		}
+		return st, true
+	})
+	return err
+}
+
+// Reset はキーの失敗記録とロックを消す
+func (l *SQLLoginLimiter) Reset(ctx context.Context, key LoginKey) (err error) {
+	ctx, done := l.store.begin(ctx, "LoginLimiter.Reset", &err)
+	defer done()
+	_, err = l.store.exec(ctx, `delete from login_attempts where key = $1`, key.String())
+	return err
+}
+
+// 各実装がインターフェースを満たしていることをコンパイル時に確認する
+var (
+	_ LoginLimiter = (*MemoryLoginLimiter)(nil)
+	_ LoginLimiter = (*SQLLoginLimiter)(nil)
+)
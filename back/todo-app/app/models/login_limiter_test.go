@@ -0,0 +1,218 @@
+package models_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/app/models"
+	"todo-app/config"
+)
+
+// ログイン試行の制限の共通テスト
+// ストアの共通テストと同じく、MemoryLoginLimiter・SQLite と PostgreSQL の SQLLoginLimiter に対して実行する
+
+// testLoginPolicy は待ち時間 1s・2s・4s（上限）、メールアドレスは3回、接続元IPは10回の失敗でロックする設定
+var testLoginPolicy = models.LoginPolicy{
+	MaxEmailFailures: 3,
+	MaxIPFailures:    10,
+	Window:           time.Hour,
+	Lockout:          15 * time.Minute,
+	BaseDelay:        time.Second,
+	MaxDelay:         4 * time.Second,
+}
+
+func TestMemoryLoginLimiter(t *testing.T) {
+	testLoginLimiter(t, func(t *testing.T) models.LoginLimiter { return models.NewMemoryLoginLimiter(testLoginPolicy) })
+}
+
+func TestSQLiteLoginLimiter(t *testing.T) {
+	testLoginLimiter(t, func(t *testing.T) models.LoginLimiter {
+		// 同時の試行がデータベースのロックで順に処理されることを確かめるため、複数の接続を使う
+		c := sqliteConfig(t)
+		c.DbMaxOpenConns = 4
+		return models.NewSQLLoginLimiter(openSQLStore(t, c), testLoginPolicy)
+	})
+}
+
+func TestPostgresLoginLimiter(t *testing.T) {
+	if os.Getenv("TEST_POSTGRES") == "" {
+		t.Skip("set TEST_POSTGRES=1 and DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME to run against PostgreSQL")
+	}
+	c, _, err := config.Load([]string{"--web.port", "8080", "--db.driver", "postgres", "--web.dev", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLoginLimiter(t, func(t *testing.T) models.LoginLimiter {
+		return models.NewSQLLoginLimiter(openSQLStore(t, c), testLoginPolicy)
+	})
+}
+
+// testLoginLimiter は newLimiter で生成した LoginLimiter に対して共通のテストを実行する
+func testLoginLimiter(t *testing.T, newLimiter func(t *testing.T) models.LoginLimiter) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, l models.LoginLimiter)
+	}{
+		{"Delays", testLoginDelays},
+		{"Lockout", testLoginLockout},
+		{"Release", testLoginRelease},
+		{"ConcurrentReserve", testLoginConcurrentReserve},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newLimiter(t))
+		})
+	}
+}
+
+// reserve は試行を予約し、待ち時間が want であることを確認して記録後の状況を返す
+func reserve(t *testing.T, l models.LoginLimiter, key models.LoginKey, now time.Time, want time.Duration) models.LoginStatus {
+	t.Helper()
+	st, wait, err := l.Reserve(context.Background(), key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != want {
+		t.Fatalf("Reserve(%s) wait = %v, want %v", key, wait, want)
+	}
+	return st
+}
+
+// checkFailures はキーの失敗回数が want であることを確認する
+func checkFailures(t *testing.T, l models.LoginLimiter, key models.LoginKey, now time.Time, want int) {
+	t.Helper()
+	st, err := l.Status(context.Background(), key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Failures != want {
+		t.Fatalf("Status(%s).Failures = %d, want %d", key, st.Failures, want)
+	}
+}
+
+// 失敗ごとに待ち時間が2倍に延び（上限あり）、待ち時間中の試行は記録されない
+func testLoginDelays(t *testing.T, l models.LoginLimiter) {
+	key := models.IPLoginKey("192.0.2.1")
+	now := mustParse(t, "2024-05-01T10:00:00Z")
+
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		st := reserve(t, l, key, now, 0)
+		if st.Failures != i+1 {
+			t.Fatalf("failures = %d, want %d", st.Failures, i+1)
+		}
+		if wait := testLoginPolicy.Wait(st, now); wait != delay {
+			t.Fatalf("after %d failures: wait = %v, want %v", st.Failures, wait, delay)
+		}
+		// 待ち時間の途中の試行は拒否され、失敗回数は増えない
+		reserve(t, l, key, now.Add(delay/2), delay/2)
+		checkFailures(t, l, key, now.Add(delay/2), i+1)
+		now = now.Add(delay)
+	}
+}
+
+// 失敗回数が上限に達するとロックし、解除後は失敗回数を数え直す
+func testLoginLockout(t *testing.T, l models.LoginLimiter) {
+	key := models.EmailLoginKey("user@example.com")
+	now := mustParse(t, "2024-05-01T10:00:00Z")
+
+	reserve(t, l, key, now, 0)
+	reserve(t, l, key, now.Add(time.Second), 0)
+	lockedAt := now.Add(3 * time.Second)
+	st := reserve(t, l, key, lockedAt, 0)
+	if want := lockedAt.Add(testLoginPolicy.Lockout); !st.LockedUntil.Equal(want) {
+		t.Fatalf("LockedUntil = %v, want %v", st.LockedUntil, want)
+	}
+
+	// ロック中は解除までの時間を返し、Fail でもロックは延びない
+	reserve(t, l, key, lockedAt.Add(time.Minute), testLoginPolicy.Lockout-time.Minute)
+	st, err := l.Fail(context.Background(), key, lockedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := lockedAt.Add(testLoginPolicy.Lockout); !st.LockedUntil.Equal(want) {
+		t.Fatalf("LockedUntil after Fail = %v, want %v", st.LockedUntil, want)
+	}
+
+	unlocked := lockedAt.Add(testLoginPolicy.Lockout)
+	if st := reserve(t, l, key, unlocked, 0); st.Failures != 1 || st.Locked(unlocked) {
+		t.Fatalf("after lockout: status = %+v, want 1 failure and unlocked", st)
+	}
+
+	// Reset でロックも消える
+	reserve(t, l, key, unlocked.Add(time.Second), 0)
+	reserve(t, l, key, unlocked.Add(3*time.Second), 0)
+	if err := l.Reset(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	reserve(t, l, key, unlocked.Add(4*time.Second), 0)
+}
+
+// Release は予約した1回分の失敗を取り消し、上限を下回ればロックも解除する
+func testLoginRelease(t *testing.T, l models.LoginLimiter) {
+	key := models.EmailLoginKey("user@example.com")
+	now := mustParse(t, "2024-05-01T10:00:00Z")
+
+	reserve(t, l, key, now, 0)
+	if err := l.Release(context.Background(), key, now); err != nil {
+		t.Fatal(err)
+	}
+	checkFailures(t, l, key, now, 0)
+	reserve(t, l, key, now, 0)
+
+	// 上限に達した予約を取り消すとロックが解除される
+	reserve(t, l, key, now.Add(time.Second), 0)
+	st := reserve(t, l, key, now.Add(3*time.Second), 0)
+	if !st.Locked(now.Add(3 * time.Second)) {
+		t.Fatalf("status = %+v, want locked", st)
+	}
+	if err := l.Release(context.Background(), key, now.Add(3*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	st, err := l.Status(context.Background(), key, now.Add(3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Failures != 2 || st.Locked(now.Add(3*time.Second)) {
+		t.Fatalf("after Release: status = %+v, want 2 failures and unlocked", st)
+	}
+
+	// 記録のないキーの Release は何もしない
+	if err := l.Release(context.Background(), models.EmailLoginKey("other@example.com"), now); err != nil {
+		t.Fatal(err)
+	}
+	checkFailures(t, l, models.EmailLoginKey("other@example.com"), now, 0)
+}
+
+// 同時に送られた試行も1回ずつ数え、待ち時間中の試行は予約できない
+func testLoginConcurrentReserve(t *testing.T, l models.LoginLimiter) {
+	key := models.EmailLoginKey("user@example.com")
+	now := mustParse(t, "2024-05-01T10:00:00Z")
+
+	const n = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, wait, err := l.Reserve(context.Background(), key, now)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Fatalf("reserved %d of %d concurrent attempts, want 1", reserved, n)
+	}
+	checkFailures(t, l, key, now, 1)
+}
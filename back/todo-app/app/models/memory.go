@@ -0,0 +1,467 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore はメモリ上にデータを保持する UserStore / TodoStore / SessionStore / UserTokenStore の実装
+// テストや開発用途を想定しており、複数のゴルーチンから安全に利用できる
+// SQLStore と同じく、取り消されたコンテキストでは操作を行わずに StoreError を返す
+// プロセスが終了するとデータは失われる
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	users    map[int]User
+	todos    map[int]Todo
+	sessions map[string]Session
+	tokens   map[string]memoryUserToken // キーはトークンのハッシュ
+
+	nextUserID    int
+	nextTodoID    int
+	nextSessionID int
+}
+
+// NewMemoryStore は空の MemoryStore を生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    map[int]User{},
+		todos:    map[int]Todo{},
+		sessions: map[string]Session{},
+		tokens:   map[string]memoryUserToken{},
+	}
+}
+
+// checkContext は ctx が取り消されている場合に SQLStore と同じ種類の StoreError を返す
+// 呼び出し元の取り消しは ErrCanceled、期限切れは ErrUnavailable とし、どちらの場合も操作は行わない
+func checkContext(ctx context.Context, op string) error {
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.Canceled):
+		return &StoreError{Op: op, Kind: ErrCanceled, Err: err}
+	case err != nil:
+		return &StoreError{Op: op, Kind: ErrUnavailable, Err: err}
+	}
+	return nil
+}
+
+// CreateUser はユーザーを登録し、採番されたID・UUID・作成日時を u に設定する
+func (s *MemoryStore) CreateUser(ctx context.Context, u *User) error {
+	if err := checkContext(ctx, "CreateUser"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, u.Email) {
+			return ErrDuplicateEmail
+		}
+	}
+
+	s.nextUserID++
+	u.ID = s.nextUserID
+	u.UUID = createUUID().String()
+	u.CreatedAt = time.Now()
+
+	stored := *u
+	stored.Todos = nil
+	s.users[u.ID] = stored
+	return nil
+}
+
+// GetUser はIDでユーザーを取得する
+func (s *MemoryStore) GetUser(ctx context.Context, id int) (User, error) {
+	if err := checkContext(ctx, "GetUser"); err != nil {
+		return User{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, errNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail はメールアドレスでユーザーを取得する
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	if err := checkContext(ctx, "GetUserByEmail"); err != nil {
+		return User{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return User{}, errNotFound
+}
+
+// UpdateUser はユーザーの名前とメールアドレスを更新する
+func (s *MemoryStore) UpdateUser(ctx context.Context, u *User) error {
+	if err := checkContext(ctx, "UpdateUser"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[u.ID]
+	if !ok {
+		return errNotFound
+	}
+	for id, other := range s.users {
+		if id != u.ID && strings.EqualFold(other.Email, u.Email) {
+			return ErrDuplicateEmail
+		}
+	}
+	user.Name = u.Name
+	user.Email = u.Email
+	s.users[u.ID] = user
+	return nil
+}
+
+// UpdatePassword はハッシュ化済みのパスワードでユーザーを更新する
+func (s *MemoryStore) UpdatePassword(ctx context.Context, id int, hashed string) error {
+	if err := checkContext(ctx, "UpdatePassword"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return errNotFound
+	}
+	user.PassWord = hashed
+	s.users[id] = user
+	return nil
+}
+
+// MarkVerified はメールアドレスの確認日時を記録する
+func (s *MemoryStore) MarkVerified(ctx context.Context, id int, at time.Time) error {
+	if err := checkContext(ctx, "MarkVerified"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return errNotFound
+	}
+	user.VerifiedAt = &at
+	s.users[id] = user
+	return nil
+}
+
+// DeleteUser はIDでユーザーを削除する
+func (s *MemoryStore) DeleteUser(ctx context.Context, id int) error {
+	if err := checkContext(ctx, "DeleteUser"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return errNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// CreateTodo はTodoを登録し、採番されたIDと作成日時を t に設定する
+func (s *MemoryStore) CreateTodo(ctx context.Context, t *Todo) error {
+	if err := checkContext(ctx, "CreateTodo"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextTodoID++
+	t.ID = s.nextTodoID
+	t.CreatedAt = time.Now()
+	s.todos[t.ID] = *t
+	return nil
+}
+
+// GetTodo はユーザーが所有するTodoをIDで取得する
+func (s *MemoryStore) GetTodo(ctx context.Context, userID, id int) (Todo, error) {
+	if err := checkContext(ctx, "GetTodo"); err != nil {
+		return Todo{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todo, ok := s.todos[id]
+	if !ok || todo.UserID != userID {
+		return Todo{}, errNotFound
+	}
+	return todo, nil
+}
+
+// ListTodos はユーザーが所有するTodoを条件で絞り込み、並び替えて1ページ分取得する
+func (s *MemoryStore) ListTodos(ctx context.Context, userID int, q TodoQuery) (page TodoPage, err error) {
+	if err := checkContext(ctx, "ListTodos"); err != nil {
+		return page, err
+	}
+	if err := q.normalize(); err != nil {
+		return page, err
+	}
+
+	var cursor *Todo
+	if q.Cursor != "" {
+		c, err := q.decodeCursor()
+		if err != nil {
+			return page, err
+		}
+		// カーソルの位置を比較用のTodoとして復元する
+		cursor = &Todo{ID: c.ID, CreatedAt: c.Time, Priority: c.Value}
+		if q.Sort == TodoSortDueAt && !c.Time.Equal(noDueAt) {
+			due := c.Time
+			cursor.DueAt = &due
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var todos []Todo
+	for _, todo := range s.todos {
+		if todo.UserID != userID || !q.matches(todo, now) {
+			continue
+		}
+		if cursor != nil && q.compare(todo, *cursor) <= 0 {
+			continue
+		}
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return q.compare(todos[i], todos[j]) < 0
+	})
+
+	if len(todos) > q.Limit {
+		todos = todos[:q.Limit]
+		page.NextCursor = q.cursorFor(todos[q.Limit-1])
+	}
+	page.Todos = todos
+	return page, nil
+}
+
+// UpdateTodo はTodoの内容と状態を更新する（所有者は変更できない）
+func (s *MemoryStore) UpdateTodo(ctx context.Context, t *Todo) error {
+	if err := checkContext(ctx, "UpdateTodo"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo, ok := s.todos[t.ID]
+	if !ok || todo.UserID != t.UserID {
+		return errNotFound
+	}
+	todo.Content = t.Content
+	todo.Done = t.Done
+	todo.CompletedAt = t.CompletedAt
+	todo.DueAt = t.DueAt
+	todo.Priority = t.Priority
+	s.todos[t.ID] = todo
+	return nil
+}
+
+// DeleteTodo はユーザーが所有するTodoをIDで削除する
+func (s *MemoryStore) DeleteTodo(ctx context.Context, userID, id int) error {
+	if err := checkContext(ctx, "DeleteTodo"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo, ok := s.todos[id]
+	if !ok || todo.UserID != userID {
+		return errNotFound
+	}
+	delete(s.todos, id)
+	return nil
+}
+
+// CreateSession はユーザーに紐づく新しいセッションを作成する
+func (s *MemoryStore) CreateSession(ctx context.Context, u *User, expiresAt time.Time) (Session, error) {
+	if err := checkContext(ctx, "CreateSession"); err != nil {
+		return Session{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSessionID++
+	session := Session{
+		ID:        s.nextSessionID,
+		UUID:      createUUID().String(),
+		Email:     u.Email,
+		UserID:    u.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	s.sessions[session.UUID] = session
+	return session, nil
+}
+
+// CheckSession はUUIDで有効期限内のセッションを取得する
+func (s *MemoryStore) CheckSession(ctx context.Context, uuid string) (Session, error) {
+	if err := checkContext(ctx, "CheckSession"); err != nil {
+		return Session{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[uuid]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return Session{}, errNotFound
+	}
+	return session, nil
+}
+
+// TouchSession はセッションの有効期限を更新する
+func (s *MemoryStore) TouchSession(ctx context.Context, sess *Session, expiresAt time.Time) error {
+	if err := checkContext(ctx, "TouchSession"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sess.UUID]
+	if !ok {
+		return errNotFound
+	}
+	session.ExpiresAt = expiresAt
+	s.sessions[sess.UUID] = session
+	sess.ExpiresAt = expiresAt
+	return nil
+}
+
+// DeleteSessionByUUID はUUIDでセッションを削除する
+func (s *MemoryStore) DeleteSessionByUUID(ctx context.Context, uuid string) error {
+	if err := checkContext(ctx, "DeleteSessionByUUID"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, uuid)
+	return nil
+}
+
+// DeleteSessionsByUser はユーザーのセッションをすべて削除する
+func (s *MemoryStore) DeleteSessionsByUser(ctx context.Context, userID int) error {
+	if err := checkContext(ctx, "DeleteSessionsByUser"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for uuid, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, uuid)
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredSessions は期限切れのセッションを削除し、削除件数を返す
+func (s *MemoryStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	if err := checkContext(ctx, "DeleteExpiredSessions"); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for uuid, session := range s.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(s.sessions, uuid)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// memoryUserToken は MemoryStore に保存するワンタイムトークン
+type memoryUserToken struct {
+	userID    int
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+// CreateUserToken はユーザーの新しいワンタイムトークンを発行し、トークンを返す
+func (s *MemoryStore) CreateUserToken(ctx context.Context, userID int, purpose string, expiresAt time.Time) (string, error) {
+	if err := checkContext(ctx, "CreateUserToken"); err != nil {
+		return "", err
+	}
+	token, hash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for h, t := range s.tokens {
+		if t.userID == userID && t.purpose == purpose {
+			delete(s.tokens, h)
+		}
+	}
+	s.tokens[hash] = memoryUserToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	return token, nil
+}
+
+// LookupUserToken は有効なトークンに紐づくユーザーIDを返す（トークンは使用済みにしない）
+func (s *MemoryStore) LookupUserToken(ctx context.Context, purpose, token string) (int, error) {
+	if err := checkContext(ctx, "LookupUserToken"); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[hashUserToken(token)]
+	if !ok || t.used || t.purpose != purpose || !t.expiresAt.After(time.Now()) {
+		return 0, errNotFound
+	}
+	return t.userID, nil
+}
+
+// ConsumeUserToken は有効なトークンを使用済みにし、紐づくユーザーIDを返す
+func (s *MemoryStore) ConsumeUserToken(ctx context.Context, purpose, token string) (int, error) {
+	if err := checkContext(ctx, "ConsumeUserToken"); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashUserToken(token)
+	t, ok := s.tokens[hash]
+	if !ok || t.used || t.purpose != purpose || !t.expiresAt.After(time.Now()) {
+		return 0, errNotFound
+	}
+	t.used = true
+	s.tokens[hash] = t
+	return t.userID, nil
+}
+
+// Ping はデータベースを使わないため、ctx が取り消されていなければ成功する
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return checkContext(ctx, "Ping")
+}
+
+// PoolStats はデータベースを使わないため、種類のみを返す
+func (s *MemoryStore) PoolStats() PoolStats {
+	return PoolStats{Driver: "memory"}
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost は新しくハッシュ化する際の bcrypt のコスト
+// コストを引き上げた場合、既存ユーザーは次回ログイン時に自動で再ハッシュされる
+const passwordCost = 12
+
+// legacyHashPattern は旧方式（ソルトなし SHA1 の16進文字列）で保存されたハッシュにマッチする
+var legacyHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// HashPassword はパスワードを bcrypt でハッシュ化する
+// 生成される値にはアルゴリズム・コスト・ソルトが含まれる（例: $2a$12$...）
+func HashPassword(plaintext string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(plaintext), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// legacySHA1 は旧方式の SHA1 ハッシュを計算する（既存ハッシュの照合専用）
+func legacySHA1(plaintext string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(plaintext)))
+}
+
+// verifyPassword は保存済みハッシュとパスワードを照合する
+// ok: 照合結果、needsRehash: 旧方式またはコスト不足のため再ハッシュが必要か
+func verifyPassword(hashed, plaintext string) (ok bool, needsRehash bool) {
+	if legacyHashPattern.MatchString(hashed) {
+		ok = subtle.ConstantTimeCompare([]byte(hashed), []byte(legacySHA1(plaintext))) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plaintext)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return true, err != nil || cost < passwordCost
+}
+
+// Authenticate はユーザーのパスワードを照合する
+// 照合に成功し、保存済みハッシュが旧方式の場合は新方式で再ハッシュしてストアを更新する
+func Authenticate(ctx context.Context, users UserStore, u *User, plaintext string) bool {
+	ok, needsRehash := verifyPassword(u.PassWord, plaintext)
+	if !ok {
+		return false
+	}
+	if needsRehash {
+		// 再ハッシュに失敗してもログインは成功させ、次回ログイン時に再試行する
+		hashed, err := HashPassword(plaintext)
+		if err != nil {
+			slog.ErrorContext(ctx, "パスワード再ハッシュ失敗", "user_id", u.ID, "error", err)
+			return true
+		}
+		if err := users.UpdatePassword(ctx, u.ID, hashed); err != nil {
+			slog.ErrorContext(ctx, "パスワード再ハッシュ失敗", "user_id", u.ID, "error", err)
+			return true
+		}
+		u.PassWord = hashed
+		slog.InfoContext(ctx, "パスワードを新方式で再ハッシュしました", "user_id", u.ID)
+	}
+	return true
+}
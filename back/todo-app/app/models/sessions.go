@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// セッション情報を保持する構造体
+// セッションID, UUID, メールアドレス, ユーザーID, 作成日時, 有効期限を持つ
+// セッション管理や認証用途で利用
+type Session struct {
+	ID        int       // セッションID（主キー）
+	UUID      string    // セッションごとの一意な識別子
+	Email     string    // セッションに紐づくユーザーのメールアドレス
+	UserID    int       // 紐づくユーザーID
+	CreatedAt time.Time // セッション作成日時
+	ExpiresAt time.Time // セッション有効期限（リクエストごとに延長される）
+}
+
+// SessionPolicy はセッションの有効期限の決め方を表す
+type SessionPolicy struct {
+	Lifetime    time.Duration // 作成からの絶対的な有効期間
+	IdleTimeout time.Duration // 無操作で失効するまでの時間
+}
+
+// Expiry はセッションの新しい有効期限を計算する
+// 最終アクセスからアイドルタイムアウト後と、作成から絶対的な有効期間後のうち早い方を返す
+func (p SessionPolicy) Expiry(createdAt, now time.Time) time.Time {
+	idle := now.Add(p.IdleTimeout)
+	absolute := createdAt.Add(p.Lifetime)
+	if idle.After(absolute) {
+		return absolute
+	}
+	return idle
+}
+
+// ユーザーに紐づく新規セッションをDBに作成する関数
+// UUID生成を行い、sessionsテーブルへINSERT
+func (s *SQLStore) CreateSession(ctx context.Context, u *User, expiresAt time.Time) (session Session, err error) {
+	ctx, done := s.begin(ctx, "CreateSession", &err)
+	defer done()
+	session = Session{
+		UUID:      createUUID().String(),
+		Email:     u.Email,
+		UserID:    u.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	cmd := `insert into sessions (
+		uuid,
+		email,
+		user_id,
+		created_at,
+		expires_at) values ($1, $2, $3, $4, $5)
+		returning id`
+
+	err = s.queryRow(ctx, cmd,
+		session.UUID,
+		session.Email,
+		session.UserID,
+		session.CreatedAt,
+		session.ExpiresAt).Scan(&session.ID)
+
+	return session, err
+}
+
+// セッションUUIDが有効かDBで検証する関数
+// 有効期限内のセッションのみ返し、無効な場合は ErrNotFound を返す
+func (s *SQLStore) CheckSession(ctx context.Context, uuid string) (sess Session, err error) {
+	ctx, done := s.begin(ctx, "CheckSession", &err)
+	defer done()
+	cmd := `select id, uuid, email, user_id, created_at, expires_at
+	 from sessions where uuid = $1 and expires_at > $2`
+
+	err = s.queryRow(ctx, cmd, uuid, time.Now()).Scan(
+		&sess.ID,
+		&sess.UUID,
+		&sess.Email,
+		&sess.UserID,
+		&sess.CreatedAt,
+		&sess.ExpiresAt)
+	inLocal(&sess.CreatedAt, &sess.ExpiresAt)
+
+	return sess, err
+}
+
+// セッションの有効期限を更新する関数（スライディング更新）
+func (s *SQLStore) TouchSession(ctx context.Context, sess *Session, expiresAt time.Time) (err error) {
+	ctx, done := s.begin(ctx, "TouchSession", &err)
+	defer done()
+	cmd := `update sessions set expires_at = $1 where id = $2`
+	_, err = s.exec(ctx, cmd, expiresAt, sess.ID)
+	if err != nil {
+		return err
+	}
+	sess.ExpiresAt = expiresAt
+	return nil
+}
+
+// セッションUUIDでDBからセッションを削除する関数
+func (s *SQLStore) DeleteSessionByUUID(ctx context.Context, uuid string) (err error) {
+	ctx, done := s.begin(ctx, "DeleteSessionByUUID", &err)
+	defer done()
+	cmd := `delete from sessions where uuid = $1`
+	_, err = s.exec(ctx, cmd, uuid)
+	return err
+}
+
+// ユーザーのセッションをすべてDBから削除する関数
+// パスワードの再設定後など、他の端末のログインを無効にする場合に使う
+func (s *SQLStore) DeleteSessionsByUser(ctx context.Context, userID int) (err error) {
+	ctx, done := s.begin(ctx, "DeleteSessionsByUser", &err)
+	defer done()
+	cmd := `delete from sessions where user_id = $1`
+	_, err = s.exec(ctx, cmd, userID)
+	return err
+}
+
+// 期限切れのセッションをDBから削除し、削除件数を返す関数
+// 有効期限を持たない旧形式のセッションも削除対象とする
+func (s *SQLStore) DeleteExpiredSessions(ctx context.Context) (deleted int64, err error) {
+	ctx, done := s.begin(ctx, "DeleteExpiredSessions", &err)
+	defer done()
+	cmd := `delete from sessions where expires_at is null or expires_at <= $1`
+	result, err := s.exec(ctx, cmd, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartSessionSweeper は期限切れセッションを定期的に削除するゴルーチンを起動する
+// 返り値の関数を呼び出すとゴルーチンを停止し（実行中の削除も取り消す）、終了を待つ
+// interval が0以下の場合はスイーパーを起動しない
+func StartSessionSweeper(sessions SessionStore, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := sessions.DeleteExpiredSessions(ctx)
+				if ctx.Err() != nil {
+					// 停止により取り消された
+					return
+				}
+				if err != nil {
+					slog.Error("Session sweeper: error deleting expired sessions", "error", err)
+					continue
+				}
+				if deleted > 0 {
+					slog.Info("Session sweeper: deleted expired sessions", "count", deleted)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-finished
+	}
+}
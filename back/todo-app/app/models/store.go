@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ストアのインターフェース定義
+// コントローラーはパッケージのグローバル変数ではなく、これらのインターフェースを通してデータを扱う
+// 実装として PostgreSQL / SQLite 用の SQLStore と、テスト・開発用の MemoryStore がある
+// エラーは StoreError で返し、種類を errors.Is(err, ErrNotFound / ErrConflict / ErrUnavailable) で判定できる
+// 取得・更新・削除の対象が存在しない場合、どの実装も ErrNotFound（sql.ErrNoRows としても判定できる）を返す
+// 各メソッドはリクエストのコンテキストを受け取り、取り消された場合は処理を中断する
+
+// UserStore はユーザーの永続化を担当する
+type UserStore interface {
+	// CreateUser はユーザーを登録し、採番されたID・UUID・作成日時を u に設定する
+	// u.PassWord にはハッシュ化済みのパスワードを設定しておくこと
+	// メールアドレスが登録済み（大文字小文字を区別しない）の場合は ErrDuplicateEmail を返す
+	CreateUser(ctx context.Context, u *User) error
+	// GetUser はIDでユーザーを取得する
+	GetUser(ctx context.Context, id int) (User, error)
+	// GetUserByEmail はメールアドレスでユーザーを取得する（大文字小文字を区別しない）
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	// UpdateUser はユーザーの名前とメールアドレスを更新する
+	UpdateUser(ctx context.Context, u *User) error
+	// UpdatePassword はハッシュ化済みのパスワードでユーザーを更新する
+	UpdatePassword(ctx context.Context, id int, hashed string) error
+	// MarkVerified はメールアドレスの確認日時を記録する
+	MarkVerified(ctx context.Context, id int, at time.Time) error
+	// DeleteUser はIDでユーザーを削除する
+	DeleteUser(ctx context.Context, id int) error
+}
+
+// TodoStore はTodoの永続化を担当する
+// Todoの取得・更新・削除は常に所有ユーザーのIDで絞り込む
+type TodoStore interface {
+	// CreateTodo はTodoを登録し、採番されたIDと作成日時を t に設定する
+	CreateTodo(ctx context.Context, t *Todo) error
+	// GetTodo はユーザーが所有するTodoをIDで取得する
+	GetTodo(ctx context.Context, userID, id int) (Todo, error)
+	// ListTodos はユーザーが所有するTodoを条件で絞り込み、並び替えて1ページ分取得する
+	// 並び替えキーが不正な場合はエラー、カーソルが不正な場合は ErrInvalidCursor を返す
+	ListTodos(ctx context.Context, userID int, q TodoQuery) (TodoPage, error)
+	// UpdateTodo はTodoの内容と状態を更新する（所有者は変更できない）
+	UpdateTodo(ctx context.Context, t *Todo) error
+	// DeleteTodo はユーザーが所有するTodoをIDで削除する
+	DeleteTodo(ctx context.Context, userID, id int) error
+}
+
+// SessionStore はログインセッションの永続化を担当する
+type SessionStore interface {
+	// CreateSession はユーザーに紐づく新しいセッションを作成する
+	CreateSession(ctx context.Context, u *User, expiresAt time.Time) (Session, error)
+	// CheckSession はUUIDで有効期限内のセッションを取得する
+	CheckSession(ctx context.Context, uuid string) (Session, error)
+	// TouchSession はセッションの有効期限を更新する
+	TouchSession(ctx context.Context, sess *Session, expiresAt time.Time) error
+	// DeleteSessionByUUID はUUIDでセッションを削除する
+	DeleteSessionByUUID(ctx context.Context, uuid string) error
+	// DeleteSessionsByUser はユーザーのセッションをすべて削除する
+	DeleteSessionsByUser(ctx context.Context, userID int) error
+	// DeleteExpiredSessions は期限切れのセッションを削除し、削除件数を返す
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// UserTokenStore はメールで送るワンタイムトークンの永続化を担当する
+type UserTokenStore interface {
+	// CreateUserToken はユーザーの新しいトークンを発行して返す（同じ用途の古いトークンは無効になる）
+	CreateUserToken(ctx context.Context, userID int, purpose string, expiresAt time.Time) (string, error)
+	// LookupUserToken は有効なトークンに紐づくユーザーIDを返す（トークンは使用済みにしない）
+	LookupUserToken(ctx context.Context, purpose, token string) (int, error)
+	// ConsumeUserToken は有効なトークンを使用済みにし、紐づくユーザーIDを返す
+	ConsumeUserToken(ctx context.Context, purpose, token string) (int, error)
+}
+
+// HealthStore はデータベースの状態の確認を担当する（診断用のエンドポイントで使う）
+type HealthStore interface {
+	// Ping はデータベースに接続できるかを確認する
+	Ping(ctx context.Context) error
+	// PoolStats はデータベースの種類とコネクションプールの統計を返す
+	PoolStats() PoolStats
+}
+
+// PoolStats はデータベースの種類とコネクションプールの統計
+type PoolStats struct {
+	Driver      string // postgres / sqlite（MemoryStore の場合は memory）
+	sql.DBStats        // database/sql の統計（MemoryStore の場合はゼロ値）
+}
+
+// 各実装がインターフェースを満たしていることをコンパイル時に確認する
+var (
+	_ UserStore      = (*SQLStore)(nil)
+	_ TodoStore      = (*SQLStore)(nil)
+	_ SessionStore   = (*SQLStore)(nil)
+	_ UserTokenStore = (*SQLStore)(nil)
+	_ HealthStore    = (*SQLStore)(nil)
+	_ UserStore      = (*MemoryStore)(nil)
+	_ TodoStore      = (*MemoryStore)(nil)
+	_ SessionStore   = (*MemoryStore)(nil)
+	_ UserTokenStore = (*MemoryStore)(nil)
+	_ HealthStore    = (*MemoryStore)(nil)
+)
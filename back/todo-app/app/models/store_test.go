@@ -0,0 +1,563 @@
+package models_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"todo-app/app/migrations"
+	"todo-app/app/models"
+	"todo-app/config"
+)
+
+// ストアの共通テスト
+// 同じテストを MemoryStore・SQLite の SQLStore・PostgreSQL の SQLStore に対して実行し、実装ごとの違いを検出する
+// PostgreSQL は環境変数 TEST_POSTGRES=1 の場合のみ実行し、接続先は DB_HOST などの環境変数（config の [db]）で指定する
+// （テストのたびにテーブルを空にするため、テスト専用のデータベースを指定すること）
+
+// store はテストするストアが実装するインターフェース
+type store interface {
+	models.UserStore
+	models.TodoStore
+	models.SessionStore
+	models.UserTokenStore
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store { return models.NewMemoryStore() })
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store { return openSQLStore(t, sqliteConfig(t)) })
+}
+
+// sqliteConfig は一時ディレクトリの SQLite のデータベースに接続する設定を返す
+func sqliteConfig(t *testing.T) config.ConfigList {
+	return config.ConfigList{
+		SQLDriver:        "sqlite",
+		DbPath:           filepath.Join(t.TempDir(), "test.sqlite3"),
+		DbMaxOpenConns:   1,
+		DbConnectTimeout: time.Second,
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	if os.Getenv("TEST_POSTGRES") == "" {
+		t.Skip("set TEST_POSTGRES=1 and DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME to run against PostgreSQL")
+	}
+	c, _, err := config.Load([]string{"--web.port", "8080", "--db.driver", "postgres", "--web.dev", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, func(t *testing.T) store {
+		return openSQLStore(t, c)
+	})
+}
+
+// openSQLStore はデータベースに接続してマイグレーションを適用し、テーブルを空にした SQLStore を返す
+func openSQLStore(t *testing.T, c config.ConfigList) *models.SQLStore {
+	t.Helper()
+	db, dialect := openDB(t, c)
+	return models.NewSQLStore(db, dialect, 0)
+}
+
+// openDB はデータベースに接続してマイグレーションを適用し、テーブルを空にする
+func openDB(t *testing.T, c config.ConfigList) (*sql.DB, models.Dialect) {
+	t.Helper()
+	db, dialect, err := models.OpenDB(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if dialect == models.Postgres {
+		if _, err := db.Exec(`truncate users, todos, sessions, user_tokens, login_attempts restart identity cascade`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, dialect
+}
+
+// testStore は newStore で生成したストアに対して共通のテストを実行する（テストごとに新しいストアを使う）
+func testStore(t *testing.T, newStore func(t *testing.T) store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store)
+	}{
+		{"Users", testUsers},
+		{"TodosOwnedByUser", testTodosOwnedByUser},
+		{"SortByDueAtAcrossOffsets", testSortByDueAtAcrossOffsets},
+		{"OverdueAcrossOffsets", testOverdueAcrossOffsets},
+		{"DueRangeAcrossOffsets", testDueRangeAcrossOffsets},
+		{"PagingAcrossOffsets", testPagingAcrossOffsets},
+		{"SessionExpiryAcrossOffsets", testSessionExpiryAcrossOffsets},
+		{"UserTokens", testUserTokens},
+		{"LegacyPasswordRehash", testLegacyPasswordRehash},
+		{"CanceledContext", testCanceledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// オフセットの異なるタイムゾーン（どちらもテストを実行する環境のローカル時刻とは異なるはずのもの）
+var (
+	zoneEast = time.FixedZone("UTC+14", 14*60*60)
+	zoneWest = time.FixedZone("UTC-12", -12*60*60)
+	zoneJST  = time.FixedZone("JST", 9*60*60)
+)
+
+// mustParse は RFC 3339 形式の日時を返す
+func mustParse(t *testing.T, value string) time.Time {
+	t.Helper()
+	v, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// newUser はユーザーを登録して返す
+func newUser(t *testing.T, s store, email string) models.User {
+	t.Helper()
+	u := models.User{Name: "user", Email: email, PassWord: "hashed"}
+	if err := s.CreateUser(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// newTodo は期限付きのTodoを登録して返す（due が nil の場合は期限なし）
+func newTodo(t *testing.T, s store, user models.User, content string, due *time.Time) models.Todo {
+	t.Helper()
+	todo := models.Todo{UserID: user.ID, Content: content, DueAt: due}
+	if err := s.CreateTodo(context.Background(), &todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+// listContents は条件に一致するTodoの内容を順に返す（すべてのページを取得する）
+func listContents(t *testing.T, s store, user models.User, q models.TodoQuery) []string {
+	t.Helper()
+	var contents []string
+	for {
+		page, err := s.ListTodos(context.Background(), user.ID, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, todo := range page.Todos {
+			contents = append(contents, todo.Content)
+		}
+		if page.NextCursor == "" {
+			return contents
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// checkContents は Todo の内容の並びが want と一致することを確認する
+func checkContents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("todos = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("todos = %q, want %q", got, want)
+		}
+	}
+}
+
+func testUsers(t *testing.T, s store) {
+	ctx := context.Background()
+	u := newUser(t, s, "User@example.com")
+
+	got, err := s.GetUserByEmail(ctx, "user@EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID || got.Verified() {
+		t.Fatalf("GetUserByEmail = %+v, want unverified user %d", got, u.ID)
+	}
+
+	dup := models.User{Name: "dup", Email: "user@example.com", PassWord: "hashed"}
+	if err := s.CreateUser(ctx, &dup); !errors.Is(err, models.ErrDuplicateEmail) || !errors.Is(err, models.ErrConflict) {
+		t.Fatalf("CreateUser duplicate: err = %v, want ErrDuplicateEmail", err)
+	}
+
+	verifiedAt := time.Date(2020, 1, 2, 5, 0, 0, 0, zoneJST)
+	if err := s.MarkVerified(ctx, u.ID, verifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetUser(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.VerifiedAt == nil || !got.VerifiedAt.Equal(verifiedAt) {
+		t.Fatalf("VerifiedAt = %v, want %v", got.VerifiedAt, verifiedAt)
+	}
+
+	if err := s.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUser(ctx, u.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetUser after delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testTodosOwnedByUser(t *testing.T, s store) {
+	ctx := context.Background()
+	alice := newUser(t, s, "alice@example.com")
+	bob := newUser(t, s, "bob@example.com")
+	todo := newTodo(t, s, alice, "alice's", nil)
+
+	if _, err := s.GetTodo(ctx, bob.ID, todo.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetTodo by other user: err = %v, want ErrNotFound", err)
+	}
+	stolen := todo
+	stolen.UserID = bob.ID
+	stolen.Content = "stolen"
+	if err := s.UpdateTodo(ctx, &stolen); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("UpdateTodo by other user: err = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteTodo(ctx, bob.ID, todo.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("DeleteTodo by other user: err = %v, want ErrNotFound", err)
+	}
+
+	got, err := s.GetTodo(ctx, alice.ID, todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != "alice's" {
+		t.Fatalf("Content = %q, want unchanged", got.Content)
+	}
+}
+
+// 期限のオフセットが異なっても、時刻の順に並ぶ
+// 2020-01-02T05:00:00+09:00 は 2020-01-01T20:00:00Z で、2020-01-01T23:00:00Z より前
+func testSortByDueAtAcrossOffsets(t *testing.T, s store) {
+	user := newUser(t, s, "a@example.com")
+	jst := mustParse(t, "2020-01-02T05:00:00+09:00")
+	utc := mustParse(t, "2020-01-01T23:00:00Z")
+	west := mustParse(t, "2020-01-01T12:00:00-12:00") // 2020-01-02T00:00:00Z
+	newTodo(t, s, user, "utc", &utc)
+	newTodo(t, s, user, "none", nil)
+	newTodo(t, s, user, "west", &west)
+	newTodo(t, s, user, "jst", &jst)
+
+	checkContents(t, listContents(t, s, user, models.TodoQuery{Sort: models.TodoSortDueAt}), "jst", "utc", "west", "none")
+	checkContents(t, listContents(t, s, user, models.TodoQuery{Sort: models.TodoSortDueAt, Desc: true}), "none", "west", "utc", "jst")
+
+	// 読み込んだ期限は同じ時刻を表す
+	todos := listContents(t, s, user, models.TodoQuery{Sort: models.TodoSortDueAt, Limit: 1})
+	checkContents(t, todos, "jst", "utc", "west", "none")
+	page, err := s.ListTodos(context.Background(), user.ID, models.TodoQuery{Sort: models.TodoSortDueAt, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := page.Todos[0].DueAt; due == nil || !due.Equal(jst) {
+		t.Fatalf("DueAt = %v, want %v", due, jst)
+	}
+}
+
+// 期限切れの判定は期限のオフセットによらない
+func testOverdueAcrossOffsets(t *testing.T, s store) {
+	user := newUser(t, s, "a@example.com")
+	now := time.Now()
+	// 文字列として比較すると、+14:00 の過去の期限は現在より後、-12:00 の未来の期限は現在より前に見える
+	past := now.Add(-time.Hour).In(zoneEast)
+	future := now.Add(time.Hour).In(zoneWest)
+	newTodo(t, s, user, "past", &past)
+	newTodo(t, s, user, "future", &future)
+	newTodo(t, s, user, "none", nil)
+
+	checkContents(t, listContents(t, s, user, models.TodoQuery{Status: models.TodoViewOverdue}), "past")
+}
+
+// 期限の範囲の絞り込みは、条件と期限のオフセットによらない
+func testDueRangeAcrossOffsets(t *testing.T, s store) {
+	user := newUser(t, s, "a@example.com")
+	before := mustParse(t, "2020-01-02T08:59:00+09:00") // 2020-01-01T23:59Z
+	inside := mustParse(t, "2020-01-01T12:00:00-12:00") // 2020-01-02T00:00Z
+	after := mustParse(t, "2020-01-03T13:00:00+14:00")  // 2020-01-02T23:00Z
+	newTodo(t, s, user, "before", &before)
+	newTodo(t, s, user, "inside", &inside)
+	newTodo(t, s, user, "after", &after)
+
+	from := time.Date(2020, 1, 2, 9, 0, 0, 0, zoneJST) // 2020-01-02T00:00Z
+	to := time.Date(2020, 1, 2, 11, 0, 0, 0, zoneWest) // 2020-01-02T23:00Z
+	checkContents(t, listContents(t, s, user, models.TodoQuery{DueFrom: &from, DueTo: &to, Sort: models.TodoSortDueAt}), "inside")
+}
+
+// キーセット方式のページングは、オフセットの異なる期限をまたいでも重複・欠落しない
+func testPagingAcrossOffsets(t *testing.T, s store) {
+	user := newUser(t, s, "a@example.com")
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	zones := []*time.Location{zoneEast, zoneWest, zoneJST, time.UTC}
+	var want []string
+	for i := range 8 {
+		due := base.Add(time.Duration(i) * 30 * time.Minute).In(zones[i%len(zones)])
+		content := due.UTC().Format(time.RFC3339)
+		newTodo(t, s, user, content, &due)
+		want = append(want, content)
+	}
+	// 同じ期限のTodoはIDの順に並ぶ
+	same := base.In(zoneWest)
+	newTodo(t, s, user, "same", &same)
+	want = append(want[:1], append([]string{"same"}, want[1:]...)...)
+
+	checkContents(t, listContents(t, s, user, models.TodoQuery{Sort: models.TodoSortDueAt, Limit: 2}), want...)
+}
+
+// セッションの有効期限の判定は、有効期限のオフセットによらない
+func testSessionExpiryAcrossOffsets(t *testing.T, s store) {
+	ctx := context.Background()
+	user := newUser(t, s, "a@example.com")
+	now := time.Now()
+
+	valid, err := s.CreateSession(ctx, &user, now.Add(time.Hour).In(zoneWest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.CreateSession(ctx, &user, now.Add(-time.Hour).In(zoneEast))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CheckSession(ctx, valid.UUID); err != nil {
+		t.Fatalf("CheckSession(valid): %v", err)
+	}
+	if _, err := s.CheckSession(ctx, expired.UUID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("CheckSession(expired): err = %v, want ErrNotFound", err)
+	}
+	deleted, err := s.DeleteExpiredSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("DeleteExpiredSessions = %d, want 1", deleted)
+	}
+
+	// 有効期限を延長しても判定は変わらない
+	if err := s.TouchSession(ctx, &valid, now.Add(2*time.Hour).In(zoneEast)); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := s.CheckSession(ctx, valid.UUID)
+	if err != nil {
+		t.Fatalf("CheckSession after touch: %v", err)
+	}
+	if want := now.Add(2 * time.Hour); sess.ExpiresAt.Sub(want).Abs() > time.Millisecond {
+		t.Fatalf("ExpiresAt = %v, want %v", sess.ExpiresAt, want)
+	}
+}
+
+// トークンは有効期限内に1回だけ使える
+func testUserTokens(t *testing.T, s store) {
+	ctx := context.Background()
+	user := newUser(t, s, "a@example.com")
+	now := time.Now()
+
+	token, err := s.CreateUserToken(ctx, user.ID, models.TokenPurposeReset, now.Add(time.Hour).In(zoneWest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConsumeUserToken(ctx, models.TokenPurposeVerify, token); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("ConsumeUserToken(other purpose): err = %v, want ErrNotFound", err)
+	}
+	// LookupUserToken はトークンを使用済みにしない
+	for range 2 {
+		userID, err := s.LookupUserToken(ctx, models.TokenPurposeReset, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID != user.ID {
+			t.Fatalf("LookupUserToken = %d, want %d", userID, user.ID)
+		}
+	}
+	userID, err := s.ConsumeUserToken(ctx, models.TokenPurposeReset, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.ID {
+		t.Fatalf("ConsumeUserToken = %d, want %d", userID, user.ID)
+	}
+	if _, err := s.ConsumeUserToken(ctx, models.TokenPurposeReset, token); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("ConsumeUserToken(used): err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.LookupUserToken(ctx, models.TokenPurposeReset, token); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("LookupUserToken(used): err = %v, want ErrNotFound", err)
+	}
+
+	expired, err := s.CreateUserToken(ctx, user.ID, models.TokenPurposeReset, now.Add(-time.Hour).In(zoneEast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConsumeUserToken(ctx, models.TokenPurposeReset, expired); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("ConsumeUserToken(expired): err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.LookupUserToken(ctx, models.TokenPurposeReset, expired); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("LookupUserToken(expired): err = %v, want ErrNotFound", err)
+	}
+}
+
+// 旧方式（SHA1）のハッシュは正しいパスワードで1回照合すると bcrypt で再ハッシュされ、誤ったパスワードでは変わらない
+func testLegacyPasswordRehash(t *testing.T, s store) {
+	ctx := context.Background()
+	legacy := fmt.Sprintf("%x", sha1.Sum([]byte("legacy password")))
+	user := models.User{Name: "user", Email: "a@example.com", PassWord: legacy}
+	if err := s.CreateUser(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	stored := func() models.User {
+		t.Helper()
+		u, err := s.GetUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	u := stored()
+	if models.Authenticate(ctx, s, &u, "wrong password") {
+		t.Fatal("Authenticate(wrong password) = true")
+	}
+	if got := stored().PassWord; got != legacy {
+		t.Fatalf("hash after wrong password = %q, want unchanged %q", got, legacy)
+	}
+
+	u = stored()
+	if !models.Authenticate(ctx, s, &u, "legacy password") {
+		t.Fatal("Authenticate(legacy password) = false")
+	}
+	rehashed := stored().PassWord
+	if !strings.HasPrefix(rehashed, "$2a$12$") {
+		t.Fatalf("hash after login = %q, want bcrypt ($2a$12$...)", rehashed)
+	}
+	if u.PassWord != rehashed {
+		t.Errorf("user's hash = %q, want the stored %q", u.PassWord, rehashed)
+	}
+
+	// 再ハッシュ後は bcrypt で照合し、再び更新はしない
+	u = stored()
+	if !models.Authenticate(ctx, s, &u, "legacy password") {
+		t.Fatal("Authenticate after rehash = false")
+	}
+	if got := stored().PassWord; got != rehashed {
+		t.Errorf("hash after second login = %q, want unchanged %q", got, rehashed)
+	}
+}
+
+// 取り消されたコンテキストでは操作を行わず、取り消しは ErrCanceled、期限切れは ErrUnavailable を返す
+func testCanceledContext(t *testing.T, s store) {
+	user := newUser(t, s, "a@example.com")
+	todo := newTodo(t, s, user, "todo", nil)
+	sess, err := s.CreateSession(context.Background(), &user, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		kind error
+	}{
+		{"canceled", canceled, models.ErrCanceled},
+		{"deadline exceeded", expired, models.ErrUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := map[string]func(ctx context.Context) error{
+				"GetUser": func(ctx context.Context) error {
+					_, err := s.GetUser(ctx, user.ID)
+					return err
+				},
+				"CreateTodo": func(ctx context.Context) error {
+					return s.CreateTodo(ctx, &models.Todo{UserID: user.ID, Content: "not created"})
+				},
+				"GetTodo": func(ctx context.Context) error {
+					_, err := s.GetTodo(ctx, user.ID, todo.ID)
+					return err
+				},
+				"ListTodos": func(ctx context.Context) error {
+					_, err := s.ListTodos(ctx, user.ID, models.TodoQuery{})
+					return err
+				},
+				"DeleteTodo": func(ctx context.Context) error {
+					return s.DeleteTodo(ctx, user.ID, todo.ID)
+				},
+				"CheckSession": func(ctx context.Context) error {
+					_, err := s.CheckSession(ctx, sess.UUID)
+					return err
+				},
+				"DeleteSessionsByUser": func(ctx context.Context) error {
+					return s.DeleteSessionsByUser(ctx, user.ID)
+				},
+			}
+			for name, op := range ops {
+				if err := op(tt.ctx); !errors.Is(err, tt.kind) {
+					t.Errorf("%s: err = %v, want %v", name, err, tt.kind)
+				}
+			}
+		})
+	}
+
+	// 取り消された操作は何も変更していない
+	checkContents(t, listContents(t, s, user, models.TodoQuery{}), "todo")
+	if _, err := s.CheckSession(context.Background(), sess.UUID); err != nil {
+		t.Errorf("CheckSession after canceled delete: %v", err)
+	}
+}
+
+// 以前の書式（書き込んだ時点のオフセット付き）で保存された SQLite の日時は、マイグレーションで UTC の書式にそろえる
+func TestSQLiteMigrationNormalizesTimes(t *testing.T) {
+	db, dialect := openDB(t, sqliteConfig(t))
+	s := models.NewSQLStore(db, dialect, 0)
+	user := newUser(t, s, "a@example.com")
+
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(1); err != nil {
+		t.Fatal(err)
+	}
+	// ドライバーの既定の書式で保存する（SQLStore を通さない）
+	jst := mustParse(t, "2020-01-02T05:00:00+09:00")
+	utc := mustParse(t, "2020-01-01T23:00:00Z")
+	for content, due := range map[string]time.Time{"jst": jst, "utc": utc} {
+		if _, err := db.Exec(`insert into todos (content, user_id, created_at, due_at) values (?, ?, ?, ?)`, content, user.ID, due, due); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkContents(t, listContents(t, s, user, models.TodoQuery{Sort: models.TodoSortDueAt}), "jst", "utc")
+	page, err := s.ListTodos(context.Background(), user.ID, models.TodoQuery{Sort: models.TodoSortDueAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := page.Todos[0].DueAt; due == nil || !due.Equal(jst) {
+		t.Fatalf("DueAt = %v, want %v", due, jst)
+	}
+}
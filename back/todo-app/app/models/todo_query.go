@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Todo一覧の並び替えキー
+const (
+	TodoSortCreatedAt = "created_at" // 作成日時順（既定）
+	TodoSortDueAt     = "due_at"     // 期限順（期限なしは昇順で末尾）
+	TodoSortPriority  = "priority"   // 優先度順
+)
+
+// 1ページあたりの件数
+const (
+	DefaultTodoLimit = 50  // 指定がない場合の件数
+	MaxTodoLimit     = 100 // 指定できる最大件数
+)
+
+// ErrInvalidCursor はページングのカーソルが不正な場合のエラー
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// noDueAt は期限なしのTodoを並び替える際に使う値（期限ありのTodoより後ろに並ぶ）
+var noDueAt = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// TodoQuery はTodo一覧の絞り込み・並び替え・ページングの条件
+// ゼロ値の項目は絞り込みに使わない
+type TodoQuery struct {
+	Status      string     // TodoViewOpen / TodoViewCompleted / TodoViewOverdue（空はすべて）
+	DueFrom     *time.Time // 期限がこの日時以降
+	DueTo       *time.Time // 期限がこの日時より前
+	Priority    *int       // 優先度が一致する
+	Contains    string     // 内容にこの文字列を含む（大文字小文字を区別しない）
+	CreatedFrom *time.Time // 作成日時がこの日時以降
+	CreatedTo   *time.Time // 作成日時がこの日時より前
+	Sort        string     // 並び替えキー（TodoSortCreatedAt など、空は作成日時順）
+	Desc        bool       // 降順にするか
+	Limit       int        // 1ページの件数（0は DefaultTodoLimit）
+	Cursor      string     // 前のページの TodoPage.NextCursor
+}
+
+// TodoPage はTodo一覧の1ページ分の結果
+type TodoPage struct {
+	Todos      []Todo // このページのTodo
+	NextCursor string // 次のページを取得するためのカーソル（最後のページでは空）
+}
+
+// todoCursor はカーソルに埋め込む、前のページの最後のTodoの位置
+type todoCursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d"`
+	ID    int       `json:"id"`
+	Time  time.Time `json:"t"`
+	Value int       `json:"v,omitempty"`
+}
+
+// normalize は並び替えキーと件数を既定値・上限で補正し、不正な値の場合はエラーを返す
+func (q *TodoQuery) normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = TodoSortCreatedAt
+	case TodoSortCreatedAt, TodoSortDueAt, TodoSortPriority:
+	default:
+		return fmt.Errorf("invalid sort key %q", q.Sort)
+	}
+	switch q.Status {
+	case "", TodoViewAll, TodoViewOpen, TodoViewCompleted, TodoViewOverdue:
+	default:
+		return fmt.Errorf("invalid status %q", q.Status)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultTodoLimit
+	}
+	if q.Limit > MaxTodoLimit {
+		q.Limit = MaxTodoLimit
+	}
+	return nil
+}
+
+// cursorFor はTodoの位置を表すカーソルを生成する
+func (q TodoQuery) cursorFor(t Todo) string {
+	c := todoCursor{Sort: q.Sort, Desc: q.Desc, ID: t.ID}
+	switch q.Sort {
+	case TodoSortCreatedAt:
+		c.Time = t.CreatedAt
+	case TodoSortDueAt:
+		c.Time = noDueAt
+		if t.DueAt != nil {
+			c.Time = *t.DueAt
+		}
+	case TodoSortPriority:
+		c.Value = t.Priority
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor はカーソルを復元する
+// 並び替えの条件がカーソル作成時と異なる場合は ErrInvalidCursor を返す
+func (q TodoQuery) decodeCursor() (c todoCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// sortValue はカーソルと比較する並び替えキーの値を返す
+func (c todoCursor) sortValue() interface{} {
+	if c.Sort == TodoSortPriority {
+		return c.Value
+	}
+	return c.Time
+}
+
+// matches はTodoが絞り込み条件を満たすかを返す（MemoryStore 用）
+func (q TodoQuery) matches(t Todo, now time.Time) bool {
+	switch q.Status {
+	case TodoViewOpen:
+		if t.Done {
+			return false
+		}
+	case TodoViewCompleted:
+		if !t.Done {
+			return false
+		}
+	case TodoViewOverdue:
+		if t.Done || t.DueAt == nil || !t.DueAt.Before(now) {
+			return false
+		}
+	}
+	if q.DueFrom != nil && (t.DueAt == nil || t.DueAt.Before(*q.DueFrom)) {
+		return false
+	}
+	if q.DueTo != nil && (t.DueAt == nil || !t.DueAt.Before(*q.DueTo)) {
+		return false
+	}
+	if q.Priority != nil && t.Priority != *q.Priority {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(strings.ToLower(t.Content), strings.ToLower(q.Contains)) {
+		return false
+	}
+	if q.CreatedFrom != nil && t.CreatedAt.Before(*q.CreatedFrom) {
+		return false
+	}
+	if q.CreatedTo != nil && !t.CreatedAt.Before(*q.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// compare はTodoを並び替えキー・IDの順で比較する（昇順で a が前なら負の値）
+func (q TodoQuery) compare(a, b Todo) int {
+	var c int
+	switch q.Sort {
+	case TodoSortDueAt:
+		ad, bd := noDueAt, noDueAt
+		if a.DueAt != nil {
+			ad = *a.DueAt
+		}
+		if b.DueAt != nil {
+			bd = *b.DueAt
+		}
+		c = ad.Compare(bd)
+	case TodoSortPriority:
+		c = a.Priority - b.Priority
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = a.ID - b.ID
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// sqlArgs はクエリ引数を順に積み、対応するプレースホルダー（$1, $2, ...）を返す
+type sqlArgs []interface{}
+
+// add は引数を追加し、そのプレースホルダーを返す
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// likeEscaper は LIKE のワイルドカードをエスケープする
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListTodos は条件に一致するユーザーのTodoを並び替えて1ページ分取得する
+// ページングは並び替えキーとIDによるキーセット方式で、件数が増えても一定の速度で取得できる
+func (s *SQLStore) ListTodos(ctx context.Context, userID int, q TodoQuery) (page TodoPage, err error) {
+	ctx, done := s.begin(ctx, "ListTodos", &err)
+	defer done()
+	if err := q.normalize(); err != nil {
+		return page, err
+	}
+
+	var args sqlArgs
+	where := []string{"user_id = " + args.add(userID)}
+
+	switch q.Status {
+	case TodoViewOpen:
+		where = append(where, "done = "+args.add(false))
+	case TodoViewCompleted:
+		where = append(where, "done = "+args.add(true))
+	case TodoViewOverdue:
+		where = append(where, "done = "+args.add(false), "due_at is not null", "due_at < "+args.add(time.Now()))
+	}
+	if q.DueFrom != nil {
+		where = append(where, "due_at >= "+args.add(*q.DueFrom))
+	}
+	if q.DueTo != nil {
+		where = append(where, "due_at < "+args.add(*q.DueTo))
+	}
+	if q.Priority != nil {
+		where = append(where, "priority = "+args.add(*q.Priority))
+	}
+	if q.Contains != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q.Contains)) + "%"
+		where = append(where, "lower(content) like "+args.add(pattern)+` escape '\'`)
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "created_at >= "+args.add(*q.CreatedFrom))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "created_at < "+args.add(*q.CreatedTo))
+	}
+
+	// 並び替えキー（期限なしは最も遠い期限として扱う）
+	key := q.Sort
+	if key == TodoSortDueAt {
+		key = "coalesce(due_at, " + args.add(noDueAt) + ")"
+	}
+	op, dir := ">", "asc"
+	if q.Desc {
+		op, dir = "<", "desc"
+	}
+
+	// カーソル以降（前のページの最後のTodoより後ろ）に絞り込む
+	if q.Cursor != "" {
+		c, err := q.decodeCursor()
+		if err != nil {
+			return page, err
+		}
+		v, id := args.add(c.sortValue()), args.add(c.ID)
+		where = append(where, fmt.Sprintf("(%s %s %s or (%s = %s and id %s %s))", key, op, v, key, v, op, id))
+	}
+
+	// 次のページがあるかを判定するため、1件多く取得する
+	cmd := `select ` + todoColumns + ` from todos
+	where ` + strings.Join(where, " and ") + `
+	order by ` + key + ` ` + dir + `, id ` + dir + `
+	limit ` + args.add(q.Limit+1)
+
+	rows, err := s.query(ctx, cmd, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Todos = append(page.Todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Todos) > q.Limit {
+		page.Todos = page.Todos[:q.Limit]
+		page.NextCursor = q.cursorFor(page.Todos[q.Limit-1])
+	}
+	return page, nil
+}
@@ -1,161 +1,187 @@
 package models
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"time"
 )
 
 // Todo構造体はアプリケーションの単一のTodoアイテムを表す
-// TodoのID、内容、所有ユーザーのID、作成日時を含む
+// TodoのID、内容、所有ユーザーのID、作成日時、完了状態、期限、優先度を含む
 type Todo struct {
-	ID        int       // Todoアイテムの一意なID
-	Content   string    // Todoの内容
-	UserID    int       // このTodoを所有するユーザーのID
-	CreatedAt time.Time // Todoが作成された日時
+	ID          int        `json:"id"`           // Todoアイテムの一意なID
+	Content     string     `json:"content"`      // Todoの内容
+	UserID      int        `json:"user_id"`      // このTodoを所有するユーザーのID
+	CreatedAt   time.Time  `json:"created_at"`   // Todoが作成された日時
+	Done        bool       `json:"done"`         // 完了済みか
+	CompletedAt *time.Time `json:"completed_at"` // 完了した日時（未完了の場合は nil）
+	DueAt       *time.Time `json:"due_at"`       // 期限（未設定の場合は nil）
+	Priority    int        `json:"priority"`     // 優先度（PriorityNone 〜 PriorityHigh）
 }
 
-// Todoの内容を入力として受け取り、呼び出し元のUser構造体のIDに関連づける
-func (u *User) CreateTodo(content string) (err error) {
-	// 新しいTodoをtodosテーブルに挿入するSQLコマンド
-	cmd := `insert into todos (
-		content,
-		user_id,
-		created_at) values ($1, $2, $3)`
+// 優先度の定数
+const (
+	PriorityNone   = 0 // 優先度なし
+	PriorityLow    = 1 // 低
+	PriorityMedium = 2 // 中
+	PriorityHigh   = 3 // 高
+)
 
-	// SQLコマンドを実行し、Todo内容、ユーザーID、現在時刻を挿入
-	_, err = Db.Exec(cmd, content, u.ID, time.Now())
-	if err != nil {
-		// 実行失敗した場合に致命的なエラーをログ出力
-		log.Fatalln(err)
+// 一覧の表示切り替え用のビュー名（TodoQuery.Status に指定する）
+const (
+	TodoViewAll       = "all"       // すべて
+	TodoViewOpen      = "open"      // 未完了
+	TodoViewCompleted = "completed" // 完了済み
+	TodoViewOverdue   = "overdue"   // 期限切れ（未完了かつ期限を過ぎたもの）
+)
+
+// ValidPriority は優先度が定義済みの範囲内かを返す
+func ValidPriority(priority int) bool {
+	return priority >= PriorityNone && priority <= PriorityHigh
+}
+
+// SetDone は完了状態を設定する
+// 未完了から完了にした場合は完了日時を記録し、未完了に戻した場合は完了日時を消す
+func (t *Todo) SetDone(done bool, now time.Time) {
+	if done && !t.Done {
+		t.CompletedAt = &now
 	}
-	return err
+	if !done {
+		t.CompletedAt = nil
+	}
+	t.Done = done
 }
 
-// IDを指定してデータベースから単一のTodoアイテムを取得
-// Todo構造体と、取得に失敗した場合のエラーを返す
-func GetTodo(id int) (todo Todo, err error) {
-	// IDを指定してtodosテーブルからTodoを取得するSQLコマンド
-	cmd := `select id, content, user_id, created_at from todos
-	where id = $1`
-	// 空のTodo構造体を初期化
-	todo = Todo{}
+// Overdue は未完了のまま期限を過ぎているかを返す
+func (t Todo) Overdue() bool {
+	return !t.Done && t.DueAt != nil && t.DueAt.Before(time.Now())
+}
 
-	// クエリを実行し、結果をtodo構造体のフィールドにスキャン
-	err = Db.QueryRow(cmd, id).Scan(
+// PriorityLabel は優先度の表示名を返す
+func (t Todo) PriorityLabel() string {
+	switch t.Priority {
+	case PriorityLow:
+		return "低"
+	case PriorityMedium:
+		return "中"
+	case PriorityHigh:
+		return "高"
+	}
+	return ""
+}
+
+// todoColumns はtodosテーブルから取得するカラムの一覧（scanTodo と順序を合わせる）
+const todoColumns = `id, content, user_id, created_at, done, completed_at, due_at, priority`
+
+// scanner は *sql.Row と *sql.Rows に共通する Scan メソッドを表す
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTodo は todoColumns の順に並んだ行をTodo構造体にスキャンする
+func scanTodo(row scanner) (todo Todo, err error) {
+	err = row.Scan(
 		&todo.ID,
 		&todo.Content,
 		&todo.UserID,
-		&todo.CreatedAt)
-
-	// 取得したTodoとエラーを返す
+		&todo.CreatedAt,
+		&todo.Done,
+		&todo.CompletedAt,
+		&todo.DueAt,
+		&todo.Priority)
+	inLocal(&todo.CreatedAt, &todo.CompletedAt, &todo.DueAt)
 	return todo, err
 }
 
-// データベースから全てのTodoアイテムを取得します
-// Todo構造体のスライスと、取得に失敗した場合のエラーを返します
-func GetTodos() (todos []Todo, err error) {
-	// 全てのTodoをtodosテーブルから取得するSQLコマンド
-	cmd := `select id, content, user_id, created_at from todos`
-	// クエリを実行して全ての行を取得
-	rows, err := Db.Query(cmd)
-	if err != nil {
-		// クエリ失敗した場合に致命的なエラーをログ出力
-		log.Fatalln(err)
-	}
-	// リソース解放のため、関数の最後にrows.Close()が実行されるように遅延設定
-	defer rows.Close()
-
-	// 行をイテレート
-	for rows.Next() {
-		var todo Todo // 各行のTodo構造体を宣言
-		// 行データをTodo構造体のフィールドにスキャン
-		err = rows.Scan(&todo.ID,
-			&todo.Content,
-			&todo.UserID,
-			&todo.CreatedAt)
-		if err != nil {
-			// スキャン失敗した場合に致命的なエラーをログ出力
-			log.Fatalln(err)
-		}
-		// スキャンしたtodoをスライスに追加
-		todos = append(todos, todo)
-	}
-	// rows.Close() // deferで実行されるためコメントアウト
-
-	// Todoのスライスとエラーを返す
-	return todos, err
+// TodoをDBに登録し、採番されたIDと作成日時をTodo構造体に設定する
+// 所有ユーザーは t.UserID で指定する
+func (s *SQLStore) CreateTodo(ctx context.Context, t *Todo) (err error) {
+	ctx, done := s.begin(ctx, "CreateTodo", &err)
+	defer done()
+	// 新しいTodoをtodosテーブルに挿入し、採番されたIDを返すSQLコマンド
+	cmd := `insert into todos (
+		content,
+		user_id,
+		created_at,
+		done,
+		completed_at,
+		due_at,
+		priority) values ($1, $2, $3, $4, $5, $6, $7)
+		returning id`
+
+	t.CreatedAt = time.Now()
+
+	// SQLコマンドを実行し、Todo内容、ユーザーID、現在時刻、状態を挿入
+	err = s.queryRow(ctx, cmd,
+		t.Content,
+		t.UserID,
+		t.CreatedAt,
+		t.Done,
+		t.CompletedAt,
+		t.DueAt,
+		t.Priority).Scan(&t.ID)
+	return err
 }
 
-// 特定のユーザーに紐づく全てのTodoアイテムを取得
-// User構造体を引数として受け取り、Todo構造体のスライスとエラーを返す
-func (u *User) GetTodosByUser() (todos []Todo, err error) {
-	// 特定のユーザーIDでtodosテーブルからTodoを取得するSQLコマンド
-	cmd := `select id, content, user_id, created_at from todos
-	where user_id = $1`
+// IDを指定して、指定ユーザーが所有する単一のTodoアイテムを取得
+// 他のユーザーのTodoは存在しないものとして扱い、ErrNotFound を返す
+func (s *SQLStore) GetTodo(ctx context.Context, userID, id int) (todo Todo, err error) {
+	ctx, done := s.begin(ctx, "GetTodo", &err)
+	defer done()
+	// IDと所有ユーザーIDを指定してtodosテーブルからTodoを取得するSQLコマンド
+	cmd := `select ` + todoColumns + ` from todos
+	where id = $1 and user_id = $2`
 
-	// 特定のユーザーIDでクエリを実行
-	rows, err := Db.Query(cmd, u.ID)
-	if err != nil {
-		// クエリ失敗した場合に致命的なエラーをログ出力
-		log.Fatalln(err)
-	}
-	// リソース解放のため、関数の最後にrows.Close()が実行されるように遅延設定
-	defer rows.Close()
-
-	// 行をイテレートします。
-	for rows.Next() {
-		var todo Todo // 各行のTodo構造体を宣言
-		// 行データをTodo構造体のフィールドにスキャン
-		err = rows.Scan(
-			&todo.ID,
-			&todo.Content,
-			&todo.UserID,
-			&todo.CreatedAt)
-
-		if err != nil {
-			// スキャン失敗した場合に致命的なエラーをログ出力
-			log.Fatalln(err)
-		}
-		// スキャンしたtodoをスライスに追加
-		todos = append(todos, todo)
-	}
-	// rows.Close() // deferで実行されるためコメントアウト
+	// クエリを実行し、結果をtodo構造体のフィールドにスキャン
+	todo, err = scanTodo(s.queryRow(ctx, cmd, id, userID))
 
-	// Todoのスライスとエラーを返す
-	return todos, err
+	// 取得したTodoとエラーを返す
+	return todo, err
 }
 
 // データベース内の既存のTodoアイテムを更新
-// 呼び出し元のTodo構造体のIDを使用して、更新するアイテムを特定
-func (t *Todo) UpdateTodo() error {
-	// Todo情報を更新するSQLコマンド
-	cmd := `update todos set content = $1, user_id = $2
-	where id = $3`
-	// 新しい内容、ユーザーID、Todo IDで更新コマンドを実行
-	_, err = Db.Exec(cmd, t.Content, t.UserID, t.ID)
+// Todo構造体のIDとユーザーIDを使用して、更新するアイテムを特定
+// 所有者は変更できず、該当するアイテムがない場合は ErrNotFound を返す
+func (s *SQLStore) UpdateTodo(ctx context.Context, t *Todo) (err error) {
+	ctx, done := s.begin(ctx, "UpdateTodo", &err)
+	defer done()
+	// Todo情報を更新するSQLコマンド（所有ユーザーIDで絞り込む）
+	cmd := `update todos set content = $1, done = $2, completed_at = $3, due_at = $4, priority = $5
+	where id = $6 and user_id = $7`
+	// 新しい内容・状態、Todo ID、ユーザーIDで更新コマンドを実行
+	result, err := s.exec(ctx, cmd, t.Content, t.Done, t.CompletedAt, t.DueAt, t.Priority, t.ID, t.UserID)
+	if err == nil {
+		err = requireAffected(result)
+	}
 	if err != nil {
 		// エラーをログ出力
-		log.Printf("Error updating todo (ID %d): %v", t.ID, err)
+		slog.ErrorContext(ctx, "Error updating todo", "todo_id", t.ID, "error", err)
 		return err
 	}
 	// 成功をログ出力
-	log.Printf("Successfully updated todo (ID %d)", t.ID)
+	slog.DebugContext(ctx, "Successfully updated todo", "todo_id", t.ID)
 	return nil
 }
 
 // IDを指定してデータベースからTodoアイテムを削除
-// 呼び出し元のTodo構造体のIDを使用して、削除するアイテムを特定
-func (t *Todo) DeleteTodo() error {
-	// Todoを削除するSQLコマンド
-	cmd := `delete from todos where id = $1`
-	// Todo IDで削除コマンドを実行
-	_, err = Db.Exec(cmd, t.ID)
+// Todo IDとユーザーIDを使用して、削除するアイテムを特定
+// 該当するアイテムがない場合は ErrNotFound を返す
+func (s *SQLStore) DeleteTodo(ctx context.Context, userID, id int) (err error) {
+	ctx, done := s.begin(ctx, "DeleteTodo", &err)
+	defer done()
+	// Todoを削除するSQLコマンド（所有ユーザーIDで絞り込む）
+	cmd := `delete from todos where id = $1 and user_id = $2`
+	// Todo ID、ユーザーIDで削除コマンドを実行
+	result, err := s.exec(ctx, cmd, id, userID)
+	if err == nil {
+		err = requireAffected(result)
+	}
 	if err != nil {
 		// エラーをログ出力
-		log.Printf("Error deleting todo (ID %d): %v", t.ID, err)
+		slog.ErrorContext(ctx, "Error deleting todo", "todo_id", id, "error", err)
 		return err
 	}
 	// 成功をログ出力
-	log.Printf("Successfully deleted todo (ID %d)", t.ID)
+	slog.DebugContext(ctx, "Successfully deleted todo", "todo_id", id)
 	return nil
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// メールで送るワンタイムトークンの用途
+const (
+	TokenPurposeUnlock = "unlock" // ロックされたアカウントの解除
+	TokenPurposeReset  = "reset"  // パスワードの再設定
+	TokenPurposeVerify = "verify" // メールアドレスの確認
+)
+
+// newUserToken はランダムなトークンと、保存用のハッシュを生成する
+// トークンそのものはメールで送るだけで保存せず、DBにはハッシュのみを保存する
+func newUserToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashUserToken(token), nil
+}
+
+// hashUserToken はトークンの SHA-256 ハッシュを16進文字列で返す
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUserToken はユーザーの新しいワンタイムトークンを発行し、トークンを返す
+// 同じ用途の未使用のトークンは無効にする
+func (s *SQLStore) CreateUserToken(ctx context.Context, userID int, purpose string, expiresAt time.Time) (token string, err error) {
+	ctx, done := s.begin(ctx, "CreateUserToken", &err)
+	defer done()
+	token, hash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.exec(ctx, `delete from user_tokens where user_id = $1 and purpose = $2`, userID, purpose); err != nil {
+		return "", err
+	}
+	cmd := `insert into user_tokens (user_id, purpose, token_hash, created_at, expires_at)
+	values ($1, $2, $3, $4, $5)`
+	if _, err := s.exec(ctx, cmd, userID, purpose, hash, time.Now(), expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LookupUserToken は有効なトークンに紐づくユーザーIDを返す（トークンは使用済みにしない）
+// 存在しない・期限切れ・使用済みのトークンの場合は ErrNotFound を返す
+func (s *SQLStore) LookupUserToken(ctx context.Context, purpose, token string) (userID int, err error) {
+	ctx, done := s.begin(ctx, "LookupUserToken", &err)
+	defer done()
+	cmd := `select user_id from user_tokens
+	where token_hash = $1 and purpose = $2 and used_at is null and expires_at > $3`
+	err = s.queryRow(ctx, cmd, hashUserToken(token), purpose, time.Now()).Scan(&userID)
+	return userID, err
+}
+
+// ConsumeUserToken はトークンを使用済みにし、紐づくユーザーIDを返す
+// 存在しない・期限切れ・使用済みのトークンの場合は ErrNotFound を返す
+func (s *SQLStore) ConsumeUserToken(ctx context.Context, purpose, token string) (userID int, err error) {
+	ctx, done := s.begin(ctx, "ConsumeUserToken", &err)
+	defer done()
+	now := time.Now()
+	cmd := `update user_tokens set used_at = $1
+	where token_hash = $2 and purpose = $3 and used_at is null and expires_at > $1
+	returning user_id`
+	err = s.queryRow(ctx, cmd, now, hashUserToken(token), purpose).Scan(&userID)
+	return userID, err
+}
@@ -2,186 +2,164 @@
 package models
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"time"
 )
 
+// ErrDuplicateEmail は登録済みのメールアドレス（大文字小文字を区別しない）でユーザーを登録しようとした場合のエラー
+// ErrConflict としても判定できる
+var ErrDuplicateEmail = fmt.Errorf("%w: email address is already registered", ErrConflict)
+
 // アプリケーションのユーザー情報を保持する構造体
-// ID, UUID, 名前, メールアドレス, パスワード, 作成日時, 紐づくTodoリストを持つ
+// ID, UUID, 名前, メールアドレス, パスワード, 作成日時, メールアドレスの確認日時, 紐づくTodoリストを持つ
 // パスワードはハッシュ化して保存すること
 // Todoスライスはユーザーに紐づくタスク一覧
 type User struct {
-	ID        int       // ユーザーID（主キー）
-	UUID      string    // ユーザーごとの一意な識別子
-	Name      string    // ユーザー名
-	Email     string    // メールアドレス（ログイン用）
-	PassWord  string    // ハッシュ化済みパスワード
-	CreatedAt time.Time // レコード作成日時
-	Todos     []Todo    // ユーザーに紐づくTodoリスト
+	ID         int        // ユーザーID（主キー）
+	UUID       string     // ユーザーごとの一意な識別子
+	Name       string     // ユーザー名
+	Email      string     // メールアドレス（ログイン用）
+	PassWord   string     // ハッシュ化済みパスワード
+	CreatedAt  time.Time  // レコード作成日時
+	VerifiedAt *time.Time // メールアドレスの確認日時（未確認の場合は nil）
+	Todos      []Todo     // ユーザーに紐づくTodoリスト
+}
+
+// Verified はメールアドレスの確認が済んでいるかを返す
+func (u User) Verified() bool {
+	return u.VerifiedAt != nil
 }
 
-// セッション情報を保持する構造体
-// セッションID, UUID, メールアドレス, ユーザーID, 作成日時を持つ
-// セッション管理や認証用途で利用
-type Session struct {
-	ID        int       // セッションID（主キー）
-	UUID      string    // セッションごとの一意な識別子
-	Email     string    // セッションに紐づくユーザーのメールアドレス
-	UserID    int       // 紐づくユーザーID
-	CreatedAt time.Time // セッション作成日時
+// LogValue はログに出力する項目を ID とメールアドレス（ログ出力時に一部を伏せる）に限定する
+// パスワードハッシュや Todo の内容がログに残らないようにする
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("id", u.ID), slog.String("email", u.Email))
+}
+
+// userColumns はusersテーブルから取得するカラムの一覧（scanUser と順序を合わせる）
+const userColumns = `id, uuid, name, email, password, created_at, verified_at`
+
+// scanUser は userColumns の順に並んだ行をUser構造体にスキャンする
+func scanUser(row scanner) (user User, err error) {
+	err = row.Scan(
+		&user.ID,
+		&user.UUID,
+		&user.Name,
+		&user.Email,
+		&user.PassWord,
+		&user.CreatedAt,
+		&user.VerifiedAt)
+	inLocal(&user.CreatedAt, &user.VerifiedAt)
+	return user, err
 }
 
 // 新規ユーザーをDBに登録する関数
-// UUID生成・パスワード暗号化を行い、usersテーブルへINSERT
-func (u *User) CreateUser() (err error) {
+// UUID生成を行い、usersテーブルへINSERT（パスワードは HashPassword でハッシュ化済みであること）
+// メールアドレスが登録済みの場合は ErrDuplicateEmail を返す
+func (s *SQLStore) CreateUser(ctx context.Context, u *User) (err error) {
+	ctx, done := s.begin(ctx, "CreateUser", &err)
+	defer done()
 	cmd := `insert into users (
 		uuid,
 		name,
 		email,
 		password,
-		created_at) values ($1, $2, $3, $4, $5)`
+		created_at) values ($1, $2, $3, $4, $5)
+		returning id`
+
+	u.UUID = createUUID().String() // UUID生成
+	u.CreatedAt = time.Now()       // 作成日時
 
-	_, err = Db.Exec(cmd,
-		createUUID(), // UUID生成
+	err = s.queryRow(ctx, cmd,
+		u.UUID,
 		u.Name,
 		u.Email,
-		Encrypt(u.PassWord), // パスワード暗号化
-		time.Now())          // 作成日時
+		u.PassWord, // ハッシュ化済みパスワード
+		u.CreatedAt).Scan(&u.ID)
 
+	if s.dialect.IsUniqueViolation(err) {
+		slog.InfoContext(ctx, "ユーザー作成失敗: メールアドレスが登録済み", "email", u.Email)
+		return ErrDuplicateEmail
+	}
 	if err != nil {
-		log.Printf("ユーザー作成失敗: %v", err)
+		slog.ErrorContext(ctx, "ユーザー作成失敗", "error", err)
 		return err
 	}
-	log.Println("ユーザー作成成功")
+	slog.InfoContext(ctx, "ユーザー作成成功", "user_id", u.ID)
 	return nil
 }
 
 // ユーザーIDでDBからユーザー情報を取得する関数
 // 見つからない場合やエラー時はerrを返す
-func GetUser(id int) (user User, err error) {
-	user = User{}
-	cmd := `select id, uuid, name, email, password, created_at
+func (s *SQLStore) GetUser(ctx context.Context, id int) (user User, err error) {
+	ctx, done := s.begin(ctx, "GetUser", &err)
+	defer done()
+	cmd := `select ` + userColumns + `
 	from users where id = $1`
-	err = Db.QueryRow(cmd, id).Scan(
-		&user.ID,
-		&user.UUID,
-		&user.Name,
-		&user.Email,
-		&user.PassWord,
-		&user.CreatedAt,
-	)
-	return user, err
+	return scanUser(s.queryRow(ctx, cmd, id))
 }
 
 // ユーザー情報（名前・メール）を更新する関数
 // IDで該当ユーザーを特定し、name/emailをUPDATE
-func (u *User) UpdateUser() (err error) {
+// 他のユーザーが登録済みのメールアドレスに変更しようとした場合は ErrDuplicateEmail を返す
+func (s *SQLStore) UpdateUser(ctx context.Context, u *User) (err error) {
+	ctx, done := s.begin(ctx, "UpdateUser", &err)
+	defer done()
 	cmd := `update users set name = $1, email = $2 where id = $3`
-	_, err = Db.Exec(cmd, u.Name, u.Email, u.ID)
+	result, err := s.exec(ctx, cmd, u.Name, u.Email, u.ID)
+	if s.dialect.IsUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	return err
+	return requireAffected(result)
 }
 
-// ユーザーIDでDBからユーザーを削除する関数
-func (u *User) DeleteUser() (err error) {
-	cmd := `delete from users where id = $1`
-	_, err = Db.Exec(cmd, u.ID)
+// ハッシュ化済みのパスワードでDBを更新する関数
+func (s *SQLStore) UpdatePassword(ctx context.Context, id int, hashed string) (err error) {
+	ctx, done := s.begin(ctx, "UpdatePassword", &err)
+	defer done()
+	cmd := `update users set password = $1 where id = $2`
+	result, err := s.exec(ctx, cmd, hashed, id)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	return err
-}
-
-// メールアドレスでユーザー情報を取得する関数
-// 見つからない場合やエラー時はerrを返す
-func GetUserByEmail(email string) (user User, err error) {
-	user = User{}
-	cmd := `select id, uuid, name, email, password, created_at
-	from users where email = $1`
-	err = Db.QueryRow(cmd, email).Scan(
-		&user.ID,
-		&user.UUID,
-		&user.Name,
-		&user.Email,
-		&user.PassWord,
-		&user.CreatedAt)
-	return user, err
+	return requireAffected(result)
 }
 
-// ユーザーに紐づく新規セッションをDBに作成する関数
-// UUID生成し、sessionsテーブルへINSERT
-func (u *User) CreateSession() (session Session, err error) {
-	session = Session{}
-	cmd1 := `insert into sessions (
-		uuid, 
-		email, 
-		user_id, 
-		created_at) values ($1, $2, $3, $4)`
-
-	_, err = Db.Exec(cmd1, createUUID(), u.Email, u.ID, time.Now())
+// ユーザーIDでDBからユーザーを削除する関数
+func (s *SQLStore) DeleteUser(ctx context.Context, id int) (err error) {
+	ctx, done := s.begin(ctx, "DeleteUser", &err)
+	defer done()
+	cmd := `delete from users where id = $1`
+	result, err := s.exec(ctx, cmd, id)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-
-	cmd2 := `select id, uuid, email, user_id, created_at
-	 from sessions where user_id = $1 and email = $2`
-
-	err = Db.QueryRow(cmd2, u.ID, u.Email).Scan(
-		&session.ID,
-		&session.UUID,
-		&session.Email,
-		&session.UserID,
-		&session.CreatedAt)
-
-	return session, err
+	return requireAffected(result)
 }
 
-// セッションUUIDが有効かDBで検証する関数
-// 有効な場合はSession構造体を更新
-func (sess *Session) CheckSession() (valid bool, err error) {
-	cmd := `select id, uuid, email, user_id, created_at
-	 from sessions where uuid = $1`
-
-	err = Db.QueryRow(cmd, sess.UUID).Scan(
-		&sess.ID,
-		&sess.UUID,
-		&sess.Email,
-		&sess.UserID,
-		&sess.CreatedAt)
-
-	if err != nil {
-		valid = false
-		return
-	}
-	if sess.ID != 0 {
-		valid = true
-	}
-	return valid, err
+// メールアドレスでユーザー情報を取得する関数（大文字小文字を区別しない）
+// 見つからない場合やエラー時はerrを返す
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (user User, err error) {
+	ctx, done := s.begin(ctx, "GetUserByEmail", &err)
+	defer done()
+	cmd := `select ` + userColumns + `
+	from users where lower(email) = lower($1)`
+	return scanUser(s.queryRow(ctx, cmd, email))
 }
 
-// セッションUUIDでDBからセッションを削除する関数
-func (sess *Session) DeleteSessionByUUID() (err error) {
-	cmd := `delete from sessions where uuid = $1`
-	_, err = Db.Exec(cmd, sess.UUID)
+// メールアドレスの確認日時を記録する関数
+func (s *SQLStore) MarkVerified(ctx context.Context, id int, at time.Time) (err error) {
+	ctx, done := s.begin(ctx, "MarkVerified", &err)
+	defer done()
+	cmd := `update users set verified_at = $1 where id = $2`
+	result, err := s.exec(ctx, cmd, at, id)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	return err
-}
-
-// セッションに紐づくユーザー情報を取得する関数
-// Session.UserIDを使ってusersテーブルから検索
-func (sess *Session) GetUserBySession() (user User, err error) {
-	user = User{}
-	cmd := `select id, uuid, name, email, created_at FROM users
-	where id = $1`
-	err = Db.QueryRow(cmd, sess.UserID).Scan(
-		&user.ID,
-		&user.UUID,
-		&user.Name,
-		&user.Email,
-		&user.CreatedAt)
-	return user, err
+	return requireAffected(result)
 }
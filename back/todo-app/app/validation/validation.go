@@ -0,0 +1,114 @@
+// Package validation はフォームやAPIで受け取ったユーザー・Todoの入力を検証する
+// 検証結果は項目名ごとのエラーメッセージ（Errors）として返し、テンプレートで項目の横に表示できる
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 入力の長さの制限
+const (
+	MaxNameLength     = 255  // 名前の最大文字数（users.name の長さ）
+	MaxEmailLength    = 255  // メールアドレスの最大文字数（users.email の長さ）
+	MinPasswordLength = 8    // パスワードの最小文字数
+	MaxPasswordBytes  = 72   // パスワードの最大バイト数（bcrypt で扱える長さ）
+	MaxContentLength  = 1000 // Todoの内容の最大文字数
+)
+
+// Errors は項目名ごとのエラーメッセージ
+// 1つの項目には最初に見つかったエラーのみを保持する
+type Errors map[string]string
+
+// Add は項目のエラーを追加する（既にエラーがある項目は上書きしない）
+func (e Errors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// Valid はエラーがないかを返す
+func (e Errors) Valid() bool {
+	return len(e) == 0
+}
+
+// Error は全項目のエラーメッセージを項目名の順に1つの文字列にまとめる（ログ・APIのエラー用）
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, 0, len(e))
+	for _, field := range fields {
+		messages = append(messages, field+": "+e[field])
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Required は値が空白のみでないことを確認する
+func (e Errors) Required(field, value, label string) {
+	if strings.TrimSpace(value) == "" {
+		e.Add(field, label+"を入力してください")
+	}
+}
+
+// MaxLength は値の文字数が max 以下であることを確認する
+func (e Errors) MaxLength(field, value, label string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		e.Add(field, fmt.Sprintf("%sは%d文字以内で入力してください", label, max))
+	}
+}
+
+// Email はメールアドレスの形式であることを確認する（表示名付きの形式は受け付けない）
+func (e Errors) Email(field, value string) {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		e.Add(field, "メールアドレスの形式が正しくありません")
+	}
+}
+
+// Password はパスワードのポリシー（長さ・空白のみでないこと）を満たすことを確認する
+func (e Errors) Password(field, value string) {
+	switch {
+	case utf8.RuneCountInString(value) < MinPasswordLength:
+		e.Add(field, fmt.Sprintf("パスワードは%d文字以上で入力してください", MinPasswordLength))
+	case len(value) > MaxPasswordBytes:
+		e.Add(field, fmt.Sprintf("パスワードは%dバイト以内で入力してください", MaxPasswordBytes))
+	case strings.TrimSpace(value) == "":
+		e.Add(field, "パスワードに空白以外の文字を含めてください")
+	}
+}
+
+// Signup はユーザー登録の入力を検証する
+func Signup(name, email, password string) Errors {
+	e := Errors{}
+	e.Required("name", name, "お名前")
+	e.MaxLength("name", name, "お名前", MaxNameLength)
+	e.Required("email", email, "メールアドレス")
+	e.MaxLength("email", email, "メールアドレス", MaxEmailLength)
+	e.Email("email", email)
+	e.Password("password", password)
+	return e
+}
+
+// NewPassword はパスワード再設定の入力を検証する
+func NewPassword(password, confirmation string) Errors {
+	e := Errors{}
+	e.Password("password", password)
+	if password != confirmation {
+		e.Add("password_confirmation", "確認用のパスワードが一致しません")
+	}
+	return e
+}
+
+// Todo はTodoの内容を検証する
+func Todo(content string) Errors {
+	e := Errors{}
+	e.Required("content", content, "内容")
+	e.MaxLength("content", content, "内容", MaxContentLength)
+	return e
+}
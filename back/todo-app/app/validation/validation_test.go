@@ -0,0 +1,146 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkErrors は e の field のエラーの有無が want であることを確認する
+func checkErrors(t *testing.T, e Errors, field string, want bool) {
+	t.Helper()
+	if _, got := e[field]; got != want {
+		t.Errorf("error for %s = %v (%v), want %v", field, got, e, want)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		value   string
+		invalid bool
+	}{
+		{"user@example.com", false},
+		{"first.last+tag@sub.example.co.jp", false},
+		{"", true},
+		{"user", true},
+		{"user@", true},
+		{"@example.com", true},
+		{"user@@example.com", true},
+		{"user @example.com", true},
+		{" user@example.com", true},
+		{"User <user@example.com>", true},
+		{"<user@example.com>", true},
+		{"a@example.com, b@example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			e := Errors{}
+			e.Email("email", tt.value)
+			checkErrors(t, e, "email", tt.invalid)
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string // エラーメッセージ（エラーがない場合は空）
+	}{
+		{"min length", strings.Repeat("a", MinPasswordLength), ""},
+		{"too short", strings.Repeat("a", MinPasswordLength-1), "パスワードは8文字以上で入力してください"},
+		{"empty", "", "パスワードは8文字以上で入力してください"},
+		{"max bytes", strings.Repeat("a", MaxPasswordBytes), ""},
+		{"too many bytes", strings.Repeat("a", MaxPasswordBytes+1), "パスワードは72バイト以内で入力してください"},
+		// 長さの下限は文字数、上限は bcrypt が扱えるバイト数で数える
+		{"multibyte min length", strings.Repeat("あ", MinPasswordLength), ""},
+		{"multibyte max bytes", strings.Repeat("あ", MaxPasswordBytes/3), ""},
+		{"multibyte too many bytes", strings.Repeat("あ", MaxPasswordBytes/3+1), "パスワードは72バイト以内で入力してください"},
+		{"blank", strings.Repeat(" ", MinPasswordLength), "パスワードに空白以外の文字を含めてください"},
+		{"surrounding spaces", "  password  ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Errors{}
+			e.Password("password", tt.value)
+			if got := e["password"]; got != tt.want {
+				t.Errorf("error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTodo(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"valid", "牛乳を買う", ""},
+		{"empty", "", "内容を入力してください"},
+		{"blank", " \t\n", "内容を入力してください"},
+		{"max length", strings.Repeat("a", MaxContentLength), ""},
+		{"too long", strings.Repeat("a", MaxContentLength+1), "内容は1000文字以内で入力してください"},
+		// 長さはバイト数ではなく文字数で数える
+		{"multibyte max length", strings.Repeat("あ", MaxContentLength), ""},
+		{"multibyte too long", strings.Repeat("あ", MaxContentLength+1), "内容は1000文字以内で入力してください"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Todo(tt.content)["content"]; got != tt.want {
+				t.Errorf("error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignup(t *testing.T) {
+	tests := []struct {
+		name                     string
+		user, email, password    string
+		nameErr, emailErr, pwErr bool
+	}{
+		{"valid", "user", "user@example.com", "password", false, false, false},
+		{"all invalid", " ", "user", "short", true, true, true},
+		{"name too long", strings.Repeat("a", MaxNameLength+1), "user@example.com", "password", true, false, false},
+		{"email too long", "user", strings.Repeat("a", MaxEmailLength) + "@example.com", "password", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Signup(tt.user, tt.email, tt.password)
+			checkErrors(t, e, "name", tt.nameErr)
+			checkErrors(t, e, "email", tt.emailErr)
+			checkErrors(t, e, "password", tt.pwErr)
+			if e.Valid() != (!tt.nameErr && !tt.emailErr && !tt.pwErr) {
+				t.Errorf("Valid() = %v, errors = %v", e.Valid(), e)
+			}
+		})
+	}
+
+	// 1つの項目には最初に見つかったエラーだけを残す
+	if got, want := Signup("user", "", "password")["email"], "メールアドレスを入力してください"; got != want {
+		t.Errorf("empty email: error = %q, want %q", got, want)
+	}
+}
+
+func TestNewPassword(t *testing.T) {
+	e := NewPassword("password", "password")
+	if !e.Valid() {
+		t.Errorf("matching passwords: errors = %v", e)
+	}
+	e = NewPassword("password", "passw0rd")
+	checkErrors(t, e, "password", false)
+	checkErrors(t, e, "password_confirmation", true)
+	e = NewPassword("short", "short")
+	checkErrors(t, e, "password", true)
+	checkErrors(t, e, "password_confirmation", false)
+}
+
+func TestErrorsError(t *testing.T) {
+	e := Errors{}
+	e.Add("password", "b")
+	e.Add("email", "a")
+	e.Add("email", "ignored")
+	if got, want := e.Error(), "email: a, password: b"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+// Package views は画面のテンプレートと静的ファイル（CSS・JavaScript）をバイナリに埋め込んで提供する
+// 埋め込むことで、作業ディレクトリに関係なくサーバーを起動できる
+package views
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+//go:embed css js
+var staticFiles embed.FS
+
+// Templates は埋め込んだテンプレートファイル（layout.html など）を返す
+func Templates() fs.FS {
+	sub, err := fs.Sub(templateFiles, "templates")
+	if err != nil {
+		// 埋め込み時にディレクトリの存在は保証されている
+		panic(err)
+	}
+	return sub
+}
+
+// Static は埋め込んだ静的ファイル（css/、js/ 以下）を返す
+func Static() fs.FS {
+	return staticFiles
+}
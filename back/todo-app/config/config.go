@@ -1,12 +1,24 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 	"todo-app/utils"
 
 	"github.com/go-ini/ini"
 )
 
+// 設定の読み込み
+// 設定値は 既定値 → 設定ファイル（config/config.ini） → 環境変数 → コマンドラインフラグ の順に重ね、後のものが優先される
+// 環境変数は「セクション_キー」の大文字（例: [db] user は DB_USER）、フラグは「--セクション.キー」（例: --db.user）で指定する
+
 type ConfigList struct {
 	Port       string
 	SQLDriver  string
@@ -15,31 +27,370 @@ type ConfigList struct {
 	DbUser     string
 	DbPassword string
 	DbName     string
+	DbPath     string // SQLite のデータベースファイルのパス
 	LogFile    string
-	Static     string
+	LogLevel   string              // ログのレベル（debug / info / warn / error）
+	LogFormat  string              // ログの形式（text または json）
+	LogRotate  utils.RotateOptions // ログファイルのローテーションの設定
+	Static     string              // 開発モードで読み込むテンプレート・静的ファイルのディレクトリ
+	Dev        bool                // 開発モード（テンプレート・静的ファイルを埋め込みではなくディスクから毎回読み込む）
+	BaseURL    string              // メールに記載するリンクの基点（例: https://todo.example.com）
+	Secret     string              // クッキー（フラッシュメッセージ）の署名に使う秘密鍵
+
+	DbQueryTimeout    time.Duration // 1回のデータベース操作にかけられる時間の上限（0 の場合は制限しない）
+	DbMaxOpenConns    int           // 同時に開く接続数の上限（0 の場合は制限しない）
+	DbMaxIdleConns    int           // プールに残しておく待機中の接続数の上限
+	DbConnMaxLifetime time.Duration // 1つの接続を使い続ける時間の上限（0 の場合は制限しない）
+	DbConnMaxIdleTime time.Duration // 待機中の接続を閉じるまでの時間（0 の場合は閉じない）
+	DbConnectTimeout  time.Duration // 起動時にデータベースの準備ができるまで接続を再試行する時間
+
+	ReadTimeout       time.Duration // リクエスト全体（ボディを含む）を読み込むまでのタイムアウト
+	ReadHeaderTimeout time.Duration // リクエストヘッダーを読み込むまでのタイムアウト
+	WriteTimeout      time.Duration // レスポンスを書き込み終えるまでのタイムアウト
+	IdleTimeout       time.Duration // Keep-Alive 接続で次のリクエストを待つ時間
+	ShutdownTimeout   time.Duration // 停止時に処理中のリクエストの完了を待つ時間
+
+	SessionLifetime      time.Duration // セッションの絶対的な有効期間
+	SessionIdleTimeout   time.Duration // 無操作でセッションが失効するまでの時間
+	SessionSweepInterval time.Duration // 期限切れセッションを削除する間隔
+	CookieSecure         bool          // セッションクッキーに Secure 属性を付けるか
+
+	LoginLimiter          string        // ログイン試行の記録先（memory または db）
+	LoginMaxEmailFailures int           // メールアドレスごとにロックするまでの失敗回数
+	LoginMaxIPFailures    int           // 接続元IPごとにロックするまでの失敗回数
+	LoginWindow           time.Duration // 失敗回数を数える期間
+	LoginLockout          time.Duration // ロックする時間
+	LoginBaseDelay        time.Duration // 失敗後に次の試行まで待つ時間（失敗ごとに2倍）
+	LoginMaxDelay         time.Duration // 待ち時間の上限
+	UnlockTokenTTL        time.Duration // ロック解除メールのリンクの有効期間
+	ResetTokenTTL         time.Duration // パスワード再設定メールのリンクの有効期間
+	VerifyTokenTTL        time.Duration // メールアドレス確認メールのリンクの有効期間
+
+	MailSender string // メールの送信方法（log または file）
+	MailDir    string // sender = file の場合の書き出し先ディレクトリ
+	MailFrom   string // 差出人のメールアドレス
+
+	// Load で重ね合わせた設定値と、それぞれの出どころ（Print で使う）
+	resolved *ini.File
+	sources  map[string]string
 }
 
-var Config ConfigList
+// DefaultPath は --config を指定しなかった場合に読み込む設定ファイル
+const DefaultPath = "config/config.ini"
 
-func init() {
-	LoadConfig()
-	utils.LoggingSettings(Config.LogFile)
+// 設定値の出どころ（config print で表示する）
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// setting は設定項目の定義
+type setting struct {
+	section string
+	key     string
+	value   string // 既定値
+	usage   string // フラグの説明
+	secret  bool   // config print で値を伏せるか
 }
 
-func LoadConfig() {
-	cfg, err := ini.Load("config/config.ini")
+// settings は設定項目の一覧（config print はこの順に表示する）
+var settings = []setting{
+	{section: "web", key: "port", usage: "待ち受けるポート番号（必須）"},
+	{section: "web", key: "logfile", value: "webapp.log", usage: "ログファイルのパス"},
+	{section: "web", key: "static", value: "app/views", usage: "開発モードで読み込むテンプレート・静的ファイルのディレクトリ"},
+	{section: "web", key: "dev", value: "false", usage: "開発モード"},
+	{section: "web", key: "base_url", usage: "メールに記載するリンクの基点（既定: http://localhost:<port>）"},
+	{section: "web", key: "secret", usage: "クッキーの署名に使う秘密鍵", secret: true},
+	{section: "web", key: "read_timeout", value: "15s", usage: "リクエスト全体を読み込むまでのタイムアウト"},
+	{section: "web", key: "read_header_timeout", value: "5s", usage: "リクエストヘッダーを読み込むまでのタイムアウト"},
+	{section: "web", key: "write_timeout", value: "30s", usage: "レスポンスを書き込み終えるまでのタイムアウト"},
+	{section: "web", key: "idle_timeout", value: "2m", usage: "Keep-Alive 接続で次のリクエストを待つ時間"},
+	{section: "web", key: "shutdown_timeout", value: "30s", usage: "停止時に処理中のリクエストの完了を待つ時間"},
+
+	{section: "log", key: "level", value: "info", usage: "ログのレベル（debug / info / warn / error）"},
+	{section: "log", key: "format", value: "text", usage: "ログの形式（text / json）"},
+	{section: "log", key: "max_size_mb", value: "100", usage: "ログファイルをローテーションするサイズ（MB）"},
+	{section: "log", key: "max_age", value: "24h", usage: "ログファイルをローテーションする間隔"},
+	{section: "log", key: "max_backups", value: "7", usage: "残すローテーション済みのログファイルの数"},
+	{section: "log", key: "compress", value: "false", usage: "ローテーション済みのログファイルを gzip で圧縮するか"},
+
+	{section: "db", key: "driver", usage: "使用するデータベース（postgres / sqlite、必須）"},
+	{section: "db", key: "host", usage: "PostgreSQL のホスト名"},
+	{section: "db", key: "port", usage: "PostgreSQL のポート番号"},
+	{section: "db", key: "user", usage: "PostgreSQL のユーザー名"},
+	{section: "db", key: "password", usage: "PostgreSQL のパスワード", secret: true},
+	{section: "db", key: "dbname", usage: "PostgreSQL のデータベース名"},
+	{section: "db", key: "path", value: "todo-app.db", usage: "SQLite のデータベースファイルのパス"},
+	{section: "db", key: "query_timeout", value: "5s", usage: "1回のデータベース操作にかけられる時間の上限"},
+	{section: "db", key: "max_open_conns", value: "25", usage: "同時に開く接続数の上限"},
+	{section: "db", key: "max_idle_conns", value: "5", usage: "待機中の接続数の上限"},
+	{section: "db", key: "conn_max_lifetime", value: "30m", usage: "1つの接続を使い続ける時間の上限"},
+	{section: "db", key: "conn_max_idle_time", value: "5m", usage: "待機中の接続を閉じるまでの時間"},
+	{section: "db", key: "connect_timeout", value: "30s", usage: "起動時に接続を再試行する時間"},
+
+	{section: "session", key: "lifetime", value: "24h", usage: "セッションの絶対的な有効期間"},
+	{section: "session", key: "idle_timeout", value: "30m", usage: "無操作でセッションが失効するまでの時間"},
+	{section: "session", key: "sweep_interval", value: "10m", usage: "期限切れセッションを削除する間隔"},
+	{section: "session", key: "cookie_secure", value: "false", usage: "セッションクッキーに Secure 属性を付けるか"},
+
+	{section: "login", key: "limiter", value: "memory", usage: "ログイン試行の記録先（memory / db）"},
+	{section: "login", key: "max_email_failures", value: "5", usage: "メールアドレスごとにロックするまでの失敗回数"},
+	{section: "login", key: "max_ip_failures", value: "20", usage: "接続元IPごとにロックするまでの失敗回数"},
+	{section: "login", key: "window", value: "15m", usage: "失敗回数を数える期間"},
+	{section: "login", key: "lockout", value: "15m", usage: "ロックする時間"},
+	{section: "login", key: "base_delay", value: "1s", usage: "失敗後に次の試行まで待つ時間"},
+	{section: "login", key: "max_delay", value: "30s", usage: "待ち時間の上限"},
+	{section: "login", key: "unlock_token_ttl", value: "1h", usage: "ロック解除メールのリンクの有効期間"},
+	{section: "login", key: "reset_token_ttl", value: "1h", usage: "パスワード再設定メールのリンクの有効期間"},
+	{section: "login", key: "verify_token_ttl", value: "24h", usage: "メールアドレス確認メールのリンクの有効期間"},
+
+	{section: "mail", key: "sender", value: "log", usage: "メールの送信方法（log: 開発モードのみ / file）"},
+	{section: "mail", key: "dir", value: "mail", usage: "sender = file の場合の書き出し先ディレクトリ"},
+	{section: "mail", key: "from", value: "no-reply@localhost", usage: "差出人のメールアドレス"},
+}
+
+// name は設定項目のフラグ名（セクション.キー）を返す
+func (s setting) name() string {
+	return s.section + "." + s.key
+}
+
+// envName は設定項目の環境変数名（セクション_キーの大文字）を返す
+func (s setting) envName() string {
+	return strings.ToUpper(s.section + "_" + s.key)
+}
+
+// Load は args のフラグと環境変数・設定ファイルから設定を読み込んで返す
+// あわせてフラグ以外の残りの引数（サブコマンドとその引数）を返す
+// 設定ファイルは --config で指定でき、指定しない場合は DefaultPath が存在すれば読み込む
+// 必須の項目がない場合や値が不正な場合は、すべての誤りをまとめたエラーを返す
+func Load(args []string) (ConfigList, []string, error) {
+	fs := flag.NewFlagSet("todo-app", flag.ContinueOnError)
+	path := fs.String("config", DefaultPath, "設定ファイルのパス")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.name()] = fs.String(s.name(), "", s.usage+"（環境変数 "+s.envName()+"）")
+	}
+	if err := fs.Parse(args); err != nil {
+		return ConfigList{}, nil, err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	cfg, err := loadFile(*path, explicit["config"])
+	if err != nil {
+		return ConfigList{}, nil, err
+	}
+	origins := make(map[string]string, len(settings))
+	for _, s := range settings {
+		section := cfg.Section(s.section)
+		source := sourceFile
+		// Key は存在しないキーを作成するため、先に HasKey で設定ファイルにあるかを確認する
+		if !section.HasKey(s.key) || section.Key(s.key).String() == "" && s.value != "" {
+			section.Key(s.key).SetValue(s.value)
+			source = sourceDefault
+		}
+		key := section.Key(s.key)
+		if v, ok := os.LookupEnv(s.envName()); ok {
+			key.SetValue(v)
+			source = sourceEnv
+		}
+		if explicit[s.name()] {
+			key.SetValue(*values[s.name()])
+			source = sourceFlag
+		}
+		origins[s.name()] = source
+	}
+	if cfg.Section("web").Key("base_url").String() == "" {
+		cfg.Section("web").Key("base_url").SetValue("http://localhost:" + cfg.Section("web").Key("port").String())
+	}
+
+	c, err := parse(cfg)
 	if err != nil {
-		log.Fatalln(err)
-	}
-	Config = ConfigList{
-		Port:       cfg.Section("web").Key("port").String(),
-		LogFile:    cfg.Section("web").Key("logfile").String(),
-		SQLDriver:  cfg.Section("db").Key("driver").String(),
-		DbHost:     cfg.Section("db").Key("host").String(),
-		DbPort:     cfg.Section("db").Key("port").String(),
-		DbUser:     cfg.Section("db").Key("user").String(),
-		DbPassword: cfg.Section("db").Key("password").String(),
-		DbName:     cfg.Section("db").Key("dbname").String(),
-		Static:     cfg.Section("web").Key("static").String(),
+		return ConfigList{}, nil, err
+	}
+	c.resolved, c.sources = cfg, origins
+	return c, fs.Args(), nil
+}
+
+// loadFile は設定ファイルを読み込む
+// 既定のパスのファイルがない場合は空の設定として扱い、環境変数とフラグだけで設定できるようにする
+func loadFile(path string, explicit bool) (*ini.File, error) {
+	cfg, err := ini.Load(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return ini.Empty(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: loading %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parser は設定値を型に変換し、変換できなかった項目の誤りを集める
+type parser struct {
+	cfg  *ini.File
+	errs []error
+}
+
+// fail は項目の誤りを記録する
+func (p *parser) fail(section, key, format string, args ...interface{}) {
+	p.errs = append(p.errs, fmt.Errorf("[%s] %s: %s", section, key, fmt.Sprintf(format, args...)))
+}
+
+func (p *parser) str(section, key string) string {
+	return p.cfg.Section(section).Key(key).String()
+}
+
+// oneOf は値が候補のいずれかであることを確認する
+func (p *parser) oneOf(section, key string, candidates ...string) string {
+	v := p.str(section, key)
+	for _, c := range candidates {
+		if v == c {
+			return v
+		}
+	}
+	p.fail(section, key, "must be one of %s (got %q)", strings.Join(candidates, ", "), v)
+	return v
+}
+
+func (p *parser) int(section, key string) int {
+	v, err := p.cfg.Section(section).Key(key).Int()
+	if err != nil || v < 0 {
+		p.fail(section, key, "must be a non-negative integer (got %q)", p.str(section, key))
+	}
+	return v
+}
+
+func (p *parser) bool(section, key string) bool {
+	v, err := p.cfg.Section(section).Key(key).Bool()
+	if err != nil {
+		p.fail(section, key, "must be true or false (got %q)", p.str(section, key))
+	}
+	return v
+}
+
+func (p *parser) duration(section, key string) time.Duration {
+	v, err := p.cfg.Section(section).Key(key).Duration()
+	if err != nil || v < 0 {
+		p.fail(section, key, "must be a non-negative duration such as 30s or 5m (got %q)", p.str(section, key))
+	}
+	return v
+}
+
+// required は値が空でないことを確認する
+func (p *parser) required(section, key string) string {
+	v := p.str(section, key)
+	if v == "" {
+		p.fail(section, key, "is required")
+	}
+	return v
+}
+
+// parse は重ね合わせた設定値を ConfigList に変換し、必須の項目と値の範囲を検証する
+func parse(cfg *ini.File) (ConfigList, error) {
+	p := &parser{cfg: cfg}
+	c := ConfigList{
+		Port:      p.required("web", "port"),
+		LogFile:   p.required("web", "logfile"),
+		LogLevel:  p.oneOf("log", "level", "debug", "info", "warn", "error"),
+		LogFormat: p.oneOf("log", "format", "text", "json"),
+		LogRotate: utils.RotateOptions{
+			MaxSize:    int64(p.int("log", "max_size_mb")) * 1024 * 1024,
+			MaxAge:     p.duration("log", "max_age"),
+			MaxBackups: p.int("log", "max_backups"),
+			Compress:   p.bool("log", "compress"),
+		},
+		SQLDriver:  p.oneOf("db", "driver", "postgres", "sqlite"),
+		DbHost:     p.str("db", "host"),
+		DbPort:     p.str("db", "port"),
+		DbUser:     p.str("db", "user"),
+		DbPassword: p.str("db", "password"),
+		DbName:     p.str("db", "dbname"),
+		DbPath:     p.str("db", "path"),
+		Static:     p.str("web", "static"),
+		Dev:        p.bool("web", "dev"),
+		BaseURL:    p.str("web", "base_url"),
+		Secret:     p.str("web", "secret"),
+
+		DbQueryTimeout:    p.duration("db", "query_timeout"),
+		DbMaxOpenConns:    p.int("db", "max_open_conns"),
+		DbMaxIdleConns:    p.int("db", "max_idle_conns"),
+		DbConnMaxLifetime: p.duration("db", "conn_max_lifetime"),
+		DbConnMaxIdleTime: p.duration("db", "conn_max_idle_time"),
+		DbConnectTimeout:  p.duration("db", "connect_timeout"),
+
+		ReadTimeout:       p.duration("web", "read_timeout"),
+		ReadHeaderTimeout: p.duration("web", "read_header_timeout"),
+		WriteTimeout:      p.duration("web", "write_timeout"),
+		IdleTimeout:       p.duration("web", "idle_timeout"),
+		ShutdownTimeout:   p.duration("web", "shutdown_timeout"),
+
+		SessionLifetime:      p.duration("session", "lifetime"),
+		SessionIdleTimeout:   p.duration("session", "idle_timeout"),
+		SessionSweepInterval: p.duration("session", "sweep_interval"),
+		CookieSecure:         p.bool("session", "cookie_secure"),
+
+		LoginLimiter:          p.oneOf("login", "limiter", "memory", "db"),
+		LoginMaxEmailFailures: p.int("login", "max_email_failures"),
+		LoginMaxIPFailures:    p.int("login", "max_ip_failures"),
+		LoginWindow:           p.duration("login", "window"),
+		LoginLockout:          p.duration("login", "lockout"),
+		LoginBaseDelay:        p.duration("login", "base_delay"),
+		LoginMaxDelay:         p.duration("login", "max_delay"),
+		UnlockTokenTTL:        p.duration("login", "unlock_token_ttl"),
+		ResetTokenTTL:         p.duration("login", "reset_token_ttl"),
+		VerifyTokenTTL:        p.duration("login", "verify_token_ttl"),
+
+		MailSender: p.oneOf("mail", "sender", "log", "file"),
+		MailDir:    p.str("mail", "dir"),
+		MailFrom:   p.str("mail", "from"),
+	}
+
+	if port, err := strconv.Atoi(c.Port); c.Port != "" && (err != nil || port < 1 || port > 65535) {
+		p.fail("web", "port", "must be a port number between 1 and 65535 (got %q)", c.Port)
+	}
+	switch c.SQLDriver {
+	case "postgres":
+		for _, key := range []string{"host", "port", "user", "dbname"} {
+			p.required("db", key)
+		}
+	case "sqlite":
+		p.required("db", "path")
+	}
+	// log はメールを送らずにログに出すだけのため、開発モード以外で使うとメールが届かない
+	if c.MailSender == "log" && !c.Dev {
+		p.fail("mail", "sender", "log only writes mail to the log and is allowed only with [web] dev = true; use sender = file")
+	}
+
+	if len(p.errs) > 0 {
+		return c, fmt.Errorf("config: invalid configuration:\n%w", errors.Join(p.errs...))
+	}
+	return c, nil
+}
+
+// Print は Load で読み込んだ設定値を ini 形式で w に書き出す
+// 各値の後ろに出どころ（default / file / env / flag）を付け、秘密鍵やパスワードは伏せる
+func (c ConfigList) Print(w io.Writer) error {
+	if c.resolved == nil {
+		return errors.New("config: not loaded")
+	}
+	section := ""
+	for _, s := range settings {
+		if s.section != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = s.section
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+		v := c.resolved.Section(s.section).Key(s.key).String()
+		if s.secret && v != "" {
+			v = "[REDACTED]"
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s ; %s\n", s.key, v, c.sources[s.name()]); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// メールをログに出すだけの sender = log（既定）は開発モードでのみ使える
+func TestMailSenderLogRequiresDev(t *testing.T) {
+	base := []string{"--web.port", "8080", "--db.driver", "sqlite"}
+
+	_, _, err := Load(base)
+	if err == nil || !strings.Contains(err.Error(), "[mail] sender") {
+		t.Fatalf("Load without dev: err = %v, want [mail] sender error", err)
+	}
+	if _, _, err := Load(append(base, "--web.dev", "true")); err != nil {
+		t.Fatalf("Load with dev: %v", err)
+	}
+	if _, _, err := Load(append(base, "--mail.sender", "file")); err != nil {
+		t.Fatalf("Load with sender = file: %v", err)
+	}
+}
+
+// clearEnv はテスト中、設定項目の環境変数をすべて未設定にする（テストの終了時に元に戻す）
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, s := range settings {
+		t.Setenv(s.envName(), "")
+		os.Unsetenv(s.envName())
+	}
+}
+
+// writeConfig は content の設定ファイルを一時ディレクトリに書き出し、パスを返す
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 設定値は 既定値 < 設定ファイル < 環境変数 < フラグ の順に優先され、出どころを記録する
+func TestLoadLayers(t *testing.T) {
+	required := []string{"--web.port", "8080", "--db.driver", "sqlite", "--web.dev", "true"}
+	file := writeConfig(t, "[log]\nlevel = warn\n[db]\nquery_timeout = 7s\n")
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		level  string
+		source string
+	}{
+		{"default", nil, nil, "info", sourceDefault},
+		{"file", []string{"--config", file}, nil, "warn", sourceFile},
+		{"env over file", []string{"--config", file}, map[string]string{"LOG_LEVEL": "error"}, "error", sourceEnv},
+		{"flag over env", []string{"--config", file, "--log.level", "debug"}, map[string]string{"LOG_LEVEL": "error"}, "debug", sourceFlag},
+		{"flag without file", []string{"--log.level", "debug"}, nil, "debug", sourceFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, _, err := Load(append(append([]string{}, required...), tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.LogLevel != tt.level {
+				t.Errorf("LogLevel = %q, want %q", c.LogLevel, tt.level)
+			}
+			if got := c.sources["log.level"]; got != tt.source {
+				t.Errorf("source = %q, want %q", got, tt.source)
+			}
+		})
+	}
+
+	// 上書きしていない項目は下の層の値のまま
+	clearEnv(t)
+	c, _, err := Load(append(append([]string{}, required...), "--config", file, "--log.level", "debug"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DbQueryTimeout.String() != "7s" || c.sources["db.query_timeout"] != sourceFile {
+		t.Errorf("DbQueryTimeout = %v (%s), want 7s from file", c.DbQueryTimeout, c.sources["db.query_timeout"])
+	}
+	if c.ReadTimeout.String() != "15s" || c.sources["web.read_timeout"] != sourceDefault {
+		t.Errorf("ReadTimeout = %v (%s), want 15s by default", c.ReadTimeout, c.sources["web.read_timeout"])
+	}
+}
+
+// 残りの引数はサブコマンドとして返す
+func TestLoadArgs(t *testing.T) {
+	clearEnv(t)
+	_, args, err := Load([]string{"--web.port", "8080", "--db.driver", "sqlite", "--web.dev", "true", "migrate", "down", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, " ") != "migrate down 1" {
+		t.Errorf("args = %q, want [migrate down 1]", args)
+	}
+}
+
+// --config で指定したファイルは存在しなければエラー、既定のパスのファイルはなくてもよい
+func TestLoadConfigPath(t *testing.T) {
+	clearEnv(t)
+	required := []string{"--web.port", "8080", "--db.driver", "sqlite", "--web.dev", "true"}
+
+	missing := filepath.Join(t.TempDir(), "missing.ini")
+	if _, _, err := Load(append([]string{"--config", missing}, required...)); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Load(--config missing): err = %v, want error naming %s", err, missing)
+	}
+	// テストはパッケージのディレクトリで実行するため、既定のパス（config/config.ini）は存在しない
+	if _, _, err := Load(required); err != nil {
+		t.Errorf("Load without config file: %v", err)
+	}
+
+	// 必須の項目も設定ファイルで指定できる
+	file := writeConfig(t, "[web]\nport = 9090\ndev = true\n[db]\ndriver = sqlite\n")
+	c, _, err := Load([]string{"--config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "9090" || c.BaseURL != "http://localhost:9090" {
+		t.Errorf("Port = %q, BaseURL = %q, want 9090 and http://localhost:9090", c.Port, c.BaseURL)
+	}
+}
+
+// 必須の項目がない場合や値が不正な場合は、すべての誤りをまとめて返す
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"required", []string{"--web.dev", "true"}, []string{"[web] port: is required", "[db] driver: must be one of"}},
+		{"postgres", []string{"--web.port", "8080", "--web.dev", "true", "--db.driver", "postgres", "--db.host", "localhost"},
+			[]string{"[db] port: is required", "[db] user: is required", "[db] dbname: is required"}},
+		{"sqlite path", []string{"--web.port", "8080", "--web.dev", "true", "--db.driver", "sqlite", "--db.path", ""}, []string{"[db] path: is required"}},
+		{"invalid values", []string{"--web.port", "70000", "--web.dev", "maybe", "--db.driver", "sqlite", "--db.query_timeout", "soon", "--login.max_ip_failures", "-1", "--log.format", "xml"},
+			[]string{"[web] port: must be a port number", "[web] dev: must be true or false", "[db] query_timeout: must be a non-negative duration",
+				"[login] max_ip_failures: must be a non-negative integer", "[log] format: must be one of"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			_, _, err := Load(tt.args)
+			if err == nil {
+				t.Fatal("Load: err = nil, want validation errors")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// config print は値と出どころを表示し、秘密鍵とパスワードは伏せる
+func TestPrintRedactsSecrets(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_PASSWORD", "db-password-value")
+	c, _, err := Load([]string{"--web.port", "8080", "--web.dev", "true", "--db.driver", "postgres",
+		"--db.host", "localhost", "--db.port", "5432", "--db.user", "todo", "--db.dbname", "todo", "--web.secret", "secret-value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	for _, secret := range []string{"secret-value", "db-password-value"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("output contains %q:\n%s", secret, printed)
+		}
+	}
+	for _, want := range []string{"secret = [REDACTED] ; flag\n", "password = [REDACTED] ; env\n", "user = todo ; flag\n", "level = info ; default\n", "[db]\n"} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("output does not contain %q:\n%s", want, printed)
+		}
+	}
+
+	if err := (ConfigList{}).Print(&out); err == nil {
+		t.Error("Print without Load: err = nil, want error")
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"todo-app/config"
+)
+
+// configUsage は config サブコマンドの使い方
+const configUsage = `usage:
+  go run main.go [flags] config print    読み込んだ設定値と出どころを表示する（パスワードなどは伏せる）`
+
+// runConfig は config サブコマンドを実行する
+func runConfig(c config.ConfigList, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", configUsage)
+	}
+	switch args[0] {
+	case "print":
+		return c.Print(os.Stdout)
+	default:
+		return fmt.Errorf("unknown config command %q\n%s", args[0], configUsage)
+	}
+}
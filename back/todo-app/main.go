@@ -1,127 +1,93 @@
 package main
 
 import (
-	"log"
-	"todo-app/app/controllers"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"todo-app/app"
+	"todo-app/config"
 )
 
+// fatal はエラーをログに出力し、アプリケーションの資源を解放してから終了する
+func fatal(application *app.App, msg string, err error) {
+	slog.Error(msg, "error", err)
+	application.Close()
+	os.Exit(1)
+}
+
 func main() {
-	err := controllers.StartMainServer()
+	// 設定を読み込む（既定値 → 設定ファイル → 環境変数 → フラグ の順に重ねる）
+	// 残りの引数はサブコマンドとして扱う
+	c, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	/*
-		fmt.Println(config.Config.Port)
-		fmt.Println(config.Config.SQLDriver)
-		fmt.Println(config.Config.DbHost)
-		fmt.Println(config.Config.DbPort)
-		fmt.Println(config.Config.DbUser)
-		fmt.Println(config.Config.DbPassword)
-		fmt.Println(config.Config.DbName)
-		fmt.Println(config.Config.LogFile)
-
-		log.Println("test")
-	*/
-
-	/*
-		// Create User
-		fmt.Println(models.Db)
-		u := &models.User{}
-		u.Name = "test2"
-		u.Email = "test2@example.com"
-		u.PassWord = "C8zuFu44LcQH"
-
-		u.CreateUser()
-	*/
-
-	/*
-		// Get User
-			u, _ := models.GetUser(1)
-			fmt.Println(u)
-	*/
-
-	/*
-		// Update user
-		u, _ := models.GetUser(1)
-
-		fmt.Println(u)
-
-		u.Name = "test2"
-		u.Email = "test2@example.com"
-		u.UpdateUser()
-
-		u, _ = models.GetUser(1)
-
-		fmt.Println(u)
-	*/
-
-	/*
-		// Delete User
-		u, _ := models.GetUser(1)
-		u.DeleteUser()
-
-		u, _ = models.GetUser(1)
-		fmt.Println(u)
-	*/
-
-	/*
-		// Create Todo
-		u, _ := models.GetUser(3)
-		u.CreateTodo("Third Todo")
-	*/
-
-	/*
-		// Get Todo
-		t, _ := models.GetTodo(1)
-		fmt.Println(t)
-	*/
-
-	/*
-		// Get Todos
-		todos, _ := models.GetTodos()
-		for k, v := range todos {
-			fmt.Println(k, v)
-		}
-	*/
-
-	/*
-		// Get Todos ByUser
-		user2, _ := models.GetUser(3)
-		todos, _ := user2.GetTodosByUser()
-		for k, v := range todos {
-			fmt.Println(k, v)
+	// サブコマンド: go run main.go config print（データベースに接続せずに終了する）
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(c, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-	*/
-
-	/*
-		// Update Todo
-		t, _ := models.GetTodo(1)
-		t.Content = "Update Todo"
-		t.UpdateTodo()
-	*/
-
-	/*
-		// Delete Todo
-		t, _ := models.GetTodo(3)
-		t.DeleteTodo()
-	*/
+		return
+	}
 
-	/*
-		// Get User By Email
-		user, _ := models.GetUserByEmail("yuto.1028.onepiece@gmail.com")
-		fmt.Println(user)
+	// SIGINT / SIGTERM を受け取ったら処理中のリクエストを待ってから停止する
+	// （データベースの準備を待っている間に受け取った場合は起動を中断する）
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Create Session
-		session, err := user.CreateSession()
-		if err != nil {
-			log.Println(err)
+	// ロガー・データベース接続・ストア・HTTP サーバーを生成する
+	application, err := app.New(ctx, c)
+	if err != nil {
+		// ロガーを設定する前に失敗した場合もあるため、標準エラー出力にも書き出す
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// 終了時に DB 接続を閉じ、ログファイルの圧縮などの完了を待ってから閉じる
+	defer application.Close()
+
+	// サブコマンド: go run main.go migrate up | down N | status
+	if len(args) > 0 && args[0] == "migrate" {
+		db, dialect := application.DB()
+		if err := runMigrate(db, dialect, args[1:]); err != nil {
+			fatal(application, "migrate command failed", err)
 		}
-		fmt.Println(session)
+		return
+	}
 
-		// Check Session
-		valid, _ := session.CheckSession()
-		fmt.Println(valid)
-	*/
+	// SIGHUP を受け取ったらログファイルを開き直す（外部の logrotate でファイルの名前が変えられた後に送る）
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := application.ReopenLogFile(); err != nil {
+				slog.Error("failed to reopen log file", "error", err)
+				continue
+			}
+			slog.Info("log file reopened")
+		}
+	}()
 
+	if err := application.Start(); err != nil {
+		fatal(application, "failed to start server", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-application.Done():
+		// シグナルを受け取る前にサーバーが停止した場合
+	}
+	if err := application.Stop(); err != nil {
+		slog.Error("server error", "error", err)
+	}
 }
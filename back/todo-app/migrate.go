@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"todo-app/app/migrations"
+	"todo-app/app/models"
+)
+
+// migrateUsage は migrate サブコマンドの使い方
+const migrateUsage = `usage:
+  go run main.go [flags] migrate up        未適用のマイグレーションをすべて適用する
+  go run main.go [flags] migrate down N    適用済みのマイグレーションを新しい順に N 件取り消す
+  go run main.go [flags] migrate status    マイグレーションの適用状況を表示する`
+
+// runMigrate は migrate サブコマンドを実行する
+func runMigrate(db *sql.DB, dialect models.Dialect, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up()
+		fmt.Printf("applied %d migration(s)\n", count)
+		return err
+	case "down":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid count %q: %w", args[1], err)
+		}
+		count, err := migrator.Down(n)
+		fmt.Printf("reverted %d migration(s)\n", count)
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			if s.Applied {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Dirty {
+				state = "dirty"
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
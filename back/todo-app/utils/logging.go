@@ -1,17 +1,142 @@
 package utils
 
 import (
+	"context"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-func LoggingSettings(logFile string) {
-	logfile, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// ログの設定
+// log/slog で標準出力とログファイルの両方に出力する
+// 形式はテキスト（key=value）または JSON、レベルは debug / info / warn / error から選ぶ
+// リクエストのコンテキストにリクエストIDがあれば、すべての行に request_id として付ける
+// パスワードやトークンなどの値は伏せ字にし、メールアドレスは一部を伏せて出力する
+// 文字列の中の URL に含まれる token パラメーター（メールのリンクなど）も伏せ字にする
+
+// NewLogger はログファイルを開き、標準出力とログファイルの両方に出力するロガーを生成する
+// 開いたログファイルは呼び出し側で管理する（SIGHUP での開き直しと終了時のクローズ）
+// slog の既定のロガーにすると、標準の log パッケージの出力も同じハンドラ（info レベル）に送られる
+func NewLogger(logFile, level, format string, rotate RotateOptions) (*slog.Logger, *RotatingFile, error) {
+	f, err := OpenRotatingFile(logFile, rotate)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, nil, err
+	}
+	multiLogFile := io.MultiWriter(os.Stdout, f)
+	return slog.New(NewLogHandler(multiLogFile, level, format)), f, nil
+}
+
+// NewLogHandler は w に出力する slog.Handler を生成する
+// level が不正な場合は info、format が json 以外の場合はテキスト形式になる
+func NewLogHandler(w io.Writer, level, format string) slog.Handler {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		lv = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{
+		Level:       lv,
+		AddSource:   true,
+		ReplaceAttr: replaceAttr,
+	}
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return contextHandler{h}
+}
+
+// requestIDKey はコンテキストにリクエストIDを保存するためのキー
+type requestIDKey struct{}
+
+// WithRequestID はリクエストIDを保存したコンテキストを返す
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID はコンテキストに保存されたリクエストIDを返す（ない場合は空文字列）
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler はコンテキストのリクエストIDをログに付ける slog.Handler
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle はリクエストIDを属性に加えてからログを出力する
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs は属性を追加したハンドラを返す
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup はグループを追加したハンドラを返す
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redactedKeys は値を伏せ字にする属性名
+var redactedKeys = map[string]bool{
+	"password":              true,
+	"password_confirmation": true,
+	"token":                 true,
+	"csrf_token":            true,
+	"secret":                true,
+	"cookie":                true,
+	"authorization":         true,
+	"session":               true,
+}
+
+// replaceAttr は機密情報を伏せ字にし、ソースの位置を「ファイル名:行番号」に短くする
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.SourceKey {
+		if src, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.String(slog.SourceKey, filepath.Base(src.File)+":"+strconv.Itoa(src.Line))
+		}
+		return a
+	}
+	key := strings.ToLower(a.Key)
+	switch {
+	case redactedKeys[key]:
+		return slog.String(a.Key, "[REDACTED]")
+	case key == "email" && a.Value.Kind() == slog.KindString:
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	case a.Value.Kind() == slog.KindString && strings.Contains(a.Value.String(), "token="):
+		return slog.String(a.Key, RedactURLTokens(a.Value.String()))
+	}
+	return a
+}
+
+// urlTokenPattern は URL のクエリ文字列の token パラメーター
+var urlTokenPattern = regexp.MustCompile(`([?&]token=)[^&#\s]+`)
+
+// RedactURLTokens は文字列に含まれる URL の token パラメーターの値を伏せ字にする
+// （例: /password/reset?token=abc → /password/reset?token=[REDACTED]）
+// ログインやパスワード再設定に使えるリンクをログに残さないようにする
+func RedactURLTokens(s string) string {
+	return urlTokenPattern.ReplaceAllString(s, "${1}[REDACTED]")
+}
+
+// MaskEmail はメールアドレスのローカル部の先頭1文字以外を伏せる（例: a***@example.com）
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
 	}
-	multiLogFile := io.MultiWriter(os.Stdout, logfile)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(multiLogFile)
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
 }
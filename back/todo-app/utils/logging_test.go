@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// メールの本文などに含まれるリンクのトークンはログに残さない
+func TestLogHandlerRedactsURLTokens(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(&buf, "info", "json"))
+	logger.Info("mail: message",
+		"body", "以下のリンクから再設定してください\n\nhttp://localhost:8080/password/reset?token=s3cr3t-t0ken\n",
+		"link", "http://localhost:8080/verify?lang=ja&token=abc123#top",
+		"token", "raw-token")
+
+	out := buf.String()
+	for _, secret := range []string{"s3cr3t-t0ken", "abc123", "raw-token"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output contains %q: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "/password/reset?token=[REDACTED]") || !strings.Contains(out, "lang=ja&token=[REDACTED]#top") {
+		t.Errorf("log output does not keep the redacted links: %s", out)
+	}
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ログファイルのローテーション
+// ログファイルが一定のサイズを超えた、または書き込み始めてから一定の時間が過ぎた場合に
+// 「ファイル名.日時」に名前を変えて新しいファイルに書き込み始める
+// 古いファイルは gzip で圧縮でき（.gz）、指定した数を超えた分は古い順に削除する
+
+// backupTimeFormat はローテーションしたファイル名に付ける日時の形式（名前順が日時順になる）
+const backupTimeFormat = "20060102-150405.000"
+
+// RotateOptions はログファイルのローテーションの設定
+type RotateOptions struct {
+	MaxSize    int64         // このバイト数を超えたらローテーションする（0 の場合はサイズでローテーションしない）
+	MaxAge     time.Duration // ファイルに書き込み始めてからこの時間が過ぎたらローテーションする（再起動をまたいで数える。0 の場合は時間でローテーションしない）
+	MaxBackups int           // 残すローテーション済みファイルの数（0 の場合は削除しない）
+	Compress   bool          // ローテーション済みファイルを gzip で圧縮するか
+}
+
+// RotatingFile はローテーションするログファイルへの io.Writer
+// 複数のゴルーチンから同時に書き込める
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	startedAt time.Time // 現在のファイルに書き込み始めた時刻（MaxAge の起点）
+
+	wg sync.WaitGroup // 実行中の圧縮・削除処理
+	bg sync.Mutex     // 圧縮・削除を1つずつ行うためのロック
+}
+
+// OpenRotatingFile はログファイルを追記モードで開き、RotatingFile を生成する
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open はログファイルを開き、現在のサイズと書き込み始めた時刻を記録する
+// 既存のファイルに追記する場合は、再起動のたびに MaxAge の起点が延びないよう fileStartedAt で推定する
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.startedAt = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		f.startedAt = f.fileStartedAt(info)
+	}
+	return nil
+}
+
+// fileStartedAt は既存のログファイルに書き込み始めた時刻を推定する
+// 直前のローテーションの日時（最新のローテーション済みファイルの名前）を使い、
+// ローテーション済みファイルがない場合はファイルの更新日時を使う
+func (f *RotatingFile) fileStartedAt(info os.FileInfo) time.Time {
+	modTime := info.ModTime()
+	backups, err := f.backups()
+	if err != nil || len(backups) == 0 {
+		return modTime
+	}
+	rotatedAt, err := time.ParseInLocation(backupTimeFormat, backupStamp(f.path, backups[len(backups)-1]), time.Local)
+	if err != nil || rotatedAt.After(modTime) {
+		return modTime
+	}
+	return rotatedAt
+}
+
+// Write はログを書き込む（書き込むとサイズの上限を超える場合や期限が過ぎた場合は先にローテーションする）
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.shouldRotate(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			// ローテーションに失敗しても、ログは現在のファイルに書き続ける
+			fmt.Fprintln(os.Stderr, "log rotation failed:", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// shouldRotate は n バイト書き込む前にローテーションが必要かを返す
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && time.Since(f.startedAt) >= f.opts.MaxAge
+}
+
+// rotate は現在のファイルの名前を変えて新しいファイルを開き、古いファイルの圧縮・削除を始める
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		// 名前を変えられなかった場合は同じファイルを開き直して書き続ける
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	// 圧縮と削除には時間がかかるため、書き込みを止めないよう別のゴルーチンで行う
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.bg.Lock()
+		defer f.bg.Unlock()
+		if f.opts.Compress {
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintln(os.Stderr, "log compression failed:", err)
+			}
+		}
+		if err := f.removeOldBackups(); err != nil {
+			fmt.Fprintln(os.Stderr, "removing old log files failed:", err)
+		}
+	}()
+	return nil
+}
+
+// Reopen はログファイルを開き直す
+// 外部の logrotate などでファイルの名前が変えられた後に呼び出すと、新しいファイルに書き込み始める
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close は実行中の圧縮・削除の完了を待ってからファイルを閉じる
+// rotate は mu を取得したまま wg.Add を呼ぶため、先に mu を取得して Add と Wait が重ならないようにする
+// （圧縮・削除のゴルーチンは mu を使わないため、mu を取得したまま待ってもよい）
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wg.Wait()
+	return f.file.Close()
+}
+
+// backups はローテーション済みのファイルを古い順に返す
+func (f *RotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, backupStamp(f.path, m)); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// backupStamp はローテーション済みファイルの名前からローテーションした日時の部分を取り出す
+func backupStamp(path, backup string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(backup), filepath.Base(path)+"."), ".gz")
+}
+
+// removeOldBackups は MaxBackups を超えたローテーション済みファイルを古い順に削除する
+func (f *RotatingFile) removeOldBackups() error {
+	if f.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// compressFile はファイルを gzip で圧縮した「ファイル名.gz」を作り、元のファイルを削除する
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
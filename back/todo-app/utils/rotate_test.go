@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backupFiles はローテーション済みファイルの数を返す
+func backupFiles(t *testing.T, f *RotatingFile) int {
+	t.Helper()
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(backups)
+}
+
+// 再起動（開き直し）をまたいでも、書き込み始めてから MaxAge が過ぎたファイルはローテーションする
+func TestRotatingFileMaxAgeSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前回のローテーションが2日前で、その後も書き込まれ続けた（更新日時は新しい）ファイル
+	rotatedAt := time.Now().Add(-48 * time.Hour)
+	if err := os.WriteFile(path+"."+rotatedAt.Format(backupTimeFormat), []byte("older\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, RotateOptions{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n := backupFiles(t, f); n != 2 {
+		t.Fatalf("backups = %d, want 2 (rotated on first write after reopen)", n)
+	}
+}
+
+// ローテーション済みファイルがない場合は、ファイルの更新日時を起点にする
+func TestRotatingFileMaxAgeFromModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, RotateOptions{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n := backupFiles(t, f); n != 1 {
+		t.Fatalf("backups = %d, want 1", n)
+	}
+}
+
+// 書き込み（ローテーション）と Close が同時に起きても競合しない（go test -race で確認する）
+func TestRotatingFileCloseDuringRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 8, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				f.Write([]byte("0123456789\n"))
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}